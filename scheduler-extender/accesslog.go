@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Access log formats for extender calls.
+const (
+	accessLogNone     = "none"
+	accessLogCombined = "combined"
+	accessLogJSON     = "json"
+)
+
+// accessLogWriter captures what the access log needs from a response.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status    int
+	bytes     int
+	nodeCount int
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// recordNodeCount lets a handler report how many nodes it handled.
+func recordNodeCount(w http.ResponseWriter, n int) {
+	if lw, ok := w.(*accessLogWriter); ok {
+		lw.nodeCount = n
+	}
+}
+
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	Remote     string  `json:"remote"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	Nodes      int     `json:"nodes"`
+}
+
+// withAccessLog logs each call to next in the configured format.
+func (se *SchedulerExtender) withAccessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := se.config.Load()
+		if cfg.AccessLogFormat == accessLogNone {
+			next(w, r)
+			return
+		}
+
+		start := time.Now()
+		lw := &accessLogWriter{ResponseWriter: w}
+		next(lw, r)
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+
+		se.accessLog.Print(formatAccessLog(cfg.AccessLogFormat, r, lw, start, time.Since(start)))
+	}
+}
+
+func formatAccessLog(format string, r *http.Request, lw *accessLogWriter, start time.Time, duration time.Duration) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+
+	if format == accessLogJSON {
+		data, _ := json.Marshal(accessLogEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			Remote:     remote,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     lw.status,
+			Bytes:      lw.bytes,
+			DurationMs: float64(duration.Microseconds()) / 1000.0,
+			Nodes:      lw.nodeCount,
+		})
+		return string(data)
+	}
+
+	// Apache combined format followed by duration and node count
+	referer := r.Referer()
+	if referer == "" {
+		referer = "-"
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d \"%s\" \"%s\" %.3fms nodes=%d",
+		remote, start.Format("02/Jan/2006:15:04:05 -0700"), r.Method, r.URL.RequestURI(), r.Proto,
+		lw.status, lw.bytes, referer, r.UserAgent(), float64(duration.Microseconds())/1000.0, lw.nodeCount)
+}
+
+func validAccessLogFormat(format string) bool {
+	switch format {
+	case accessLogNone, accessLogCombined, accessLogJSON:
+		return true
+	}
+	return false
+}
+
+// newAccessLogger writes bare lines; both formats carry their own timestamp.
+func newAccessLogger() *log.Logger {
+	return log.New(log.Writer(), "", 0)
+}
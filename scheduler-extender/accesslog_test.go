@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	combined := regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "POST /prioritize HTTP/1\.1" 200 \d+ "-" "kube-scheduler" \d+\.\d{3}ms nodes=3$`)
+
+	for _, format := range []string{accessLogCombined, accessLogJSON, accessLogNone} {
+		t.Run(format, func(t *testing.T) {
+			cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.AccessLogFormat = format })
+			se := newTestExtender(t, cfg, nil)
+			var buf bytes.Buffer
+			se.accessLog = log.New(&buf, "", 0)
+
+			req := httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(extenderArgs(testPod("web", nil), "a", "b", "c")))
+			req.Header.Set("User-Agent", "kube-scheduler")
+			rec := httptest.NewRecorder()
+			se.withAccessLog(se.prioritize)(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("prioritize status %d: %s", rec.Code, rec.Body)
+			}
+			line := strings.TrimSuffix(buf.String(), "\n")
+
+			switch format {
+			case accessLogNone:
+				if line != "" {
+					t.Errorf("logging disabled but got %q", line)
+				}
+			case accessLogCombined:
+				if !combined.MatchString(line) {
+					t.Errorf("combined line %q", line)
+				}
+			case accessLogJSON:
+				var entry accessLogEntry
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("decoding %q: %v", line, err)
+				}
+				if entry.Method != http.MethodPost || entry.Path != "/prioritize" || entry.Status != http.StatusOK ||
+					entry.Nodes != 3 || entry.Remote != "192.0.2.1" || entry.Time == "" || entry.DurationMs < 0 {
+					t.Errorf("JSON entry %+v", entry)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Aggregations applied when a node exports several series for one metric.
+const (
+	aggSum    = "sum"
+	aggAvg    = "avg"
+	aggMax    = "max"
+	aggMedian = "median"
+)
+
+func validAggregation(name string) bool {
+	switch name {
+	case aggSum, aggAvg, aggMax, aggMedian:
+		return true
+	}
+	return false
+}
+
+// aggregate reduces a node's samples for one metric to a single value.
+func aggregate(name string, values []float64) (float64, error) {
+	if len(values) == 0 {
+		return 0, fmt.Errorf("no samples to aggregate")
+	}
+	if len(values) == 1 {
+		return values[0], nil
+	}
+
+	switch name {
+	case aggSum:
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum, nil
+	case aggAvg:
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values)), nil
+	case aggMax:
+		max := values[0]
+		for _, v := range values[1:] {
+			if v > max {
+				max = v
+			}
+		}
+		return max, nil
+	case aggMedian:
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2, nil
+		}
+		return sorted[mid], nil
+	}
+
+	return 0, fmt.Errorf("unknown aggregation %q", name)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func TestDuplicateSeriesAggregated(t *testing.T) {
+	// Node a exports one series per CPU for RTT and CPU utilization
+	samples := map[string][]string{
+		"ebpf_rtt_p99_milliseconds": {"10", "40", "25"},
+		"ebpf_cpu_utilization":      {"20", "80", "50"},
+	}
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		var series []string
+		for metric, values := range samples {
+			if !strings.Contains(r.Form.Get("query"), metric) {
+				continue
+			}
+			for cpu, value := range values {
+				series = append(series, fmt.Sprintf(`{"metric":{"node":"a","cpu":"%d"},"value":[1760000000,%q]}`, cpu, value))
+			}
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%s]}}`, strings.Join(series, ","))
+	}))
+	defer prom.Close()
+
+	refresh := func(aggregations map[string]string) *NodeMetrics {
+		t.Helper()
+		cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.Aggregations = aggregations })
+		se := newTestExtender(t, cfg, nil)
+		client, _ := api.NewClient(api.Config{Address: prom.URL})
+		se.promClient = v1.NewAPI(client)
+		if err := se.updateMetrics(context.Background(), cfg); err != nil {
+			t.Fatalf("updateMetrics: %v", err)
+		}
+		return se.metricsCache["a"]
+	}
+
+	// Defaults: worst case for latency, mean for utilization
+	m := refresh(nil)
+	if m.RTTp99 != 40 {
+		t.Errorf("default RTT = %v, want the max 40", m.RTTp99)
+	}
+	if m.CPUUtil != 50 {
+		t.Errorf("default CPU = %v, want the average 50", m.CPUUtil)
+	}
+
+	m = refresh(map[string]string{"rtt_p99": aggMedian, "cpu_util": aggSum})
+	if m.RTTp99 != 25 {
+		t.Errorf("median RTT = %v, want 25", m.RTTp99)
+	}
+	if m.CPUUtil != 150 {
+		t.Errorf("summed CPU = %v, want 150", m.CPUUtil)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// backendQuirks captures how a Prometheus-compatible backend deviates from
+// plain Prometheus when answering our instant queries.
+type backendQuirks struct {
+	// nodeLabels are tried in order to find the node a sample belongs to.
+	// Federated setups rename a clashing "node" label to "exported_node".
+	nodeLabels []string
+	// acceptMatrix takes the newest point of each series when an instant
+	// query comes back as a range result.
+	acceptMatrix bool
+	// replicaLabels identify HA replicas that return the same series
+	// when deduplication is off; only the first replica is kept.
+	replicaLabels []string
+	// pathPrefix is where the backend serves the Prometheus API, added to
+	// a PrometheusURL that has no path of its own.
+	pathPrefix string
+}
+
+var backendQuirksByName = map[string]backendQuirks{
+	"prometheus": {
+		nodeLabels: []string{nodeLabel},
+	},
+	"thanos": {
+		nodeLabels:    []string{nodeLabel, "exported_node"},
+		replicaLabels: []string{"replica", "prometheus_replica"},
+	},
+	"victoriametrics": {
+		nodeLabels:   []string{nodeLabel},
+		acceptMatrix: true,
+		pathPrefix:   "/prometheus",
+	},
+	"mimir": {
+		nodeLabels:    []string{nodeLabel, "exported_node"},
+		replicaLabels: []string{"__replica__"},
+	},
+}
+
+// quirksFor returns the parsing quirks for backend, falling back to plain
+// Prometheus behavior for unknown names.
+func quirksFor(backend string) backendQuirks {
+	if quirks, ok := backendQuirksByName[strings.ToLower(backend)]; ok {
+		return quirks
+	}
+	return backendQuirksByName["prometheus"]
+}
+
+// apiURL returns the address to query backend at. A URL that already has
+// a path, such as a VictoriaMetrics cluster's /select/0/prometheus, is
+// used as is.
+func apiURL(rawURL, backend string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if prefix := quirksFor(backend).pathPrefix; prefix != "" && strings.Trim(u.Path, "/") == "" {
+		u.Path = prefix
+	}
+	return u.String(), nil
+}
+
+func (q backendQuirks) nodeName(metric model.Metric) string {
+	for _, label := range q.nodeLabels {
+		if name := string(metric[model.LabelName(label)]); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// nodeSamples collects every sample per node so duplicate series (per-CPU,
+// per-interface) can be aggregated by the caller.
+type nodeSamples struct {
+	quirks  backendQuirks
+	values  map[string][]float64
+	seenSet map[model.Fingerprint]bool
+}
+
+func newNodeSamples(quirks backendQuirks) *nodeSamples {
+	return &nodeSamples{
+		quirks:  quirks,
+		values:  make(map[string][]float64),
+		seenSet: make(map[model.Fingerprint]bool),
+	}
+}
+
+func (ns *nodeSamples) add(metric model.Metric, value float64) {
+	nodeName := ns.quirks.nodeName(metric)
+	if nodeName == "" {
+		return
+	}
+
+	if len(ns.quirks.replicaLabels) > 0 {
+		series := metric.Clone()
+		for _, label := range ns.quirks.replicaLabels {
+			delete(series, model.LabelName(label))
+		}
+		fp := series.Fingerprint()
+		if ns.seenSet[fp] {
+			return
+		}
+		ns.seenSet[fp] = true
+	}
+
+	ns.values[nodeName] = append(ns.values[nodeName], value)
+}
+
+// parseNodeValues extracts the samples per node from an instant query result.
+func parseNodeValues(result model.Value, quirks backendQuirks) map[string][]float64 {
+	samples := newNodeSamples(quirks)
+
+	switch v := result.(type) {
+	case model.Vector:
+		for _, sample := range v {
+			samples.add(sample.Metric, float64(sample.Value))
+		}
+	case model.Matrix:
+		if !quirks.acceptMatrix {
+			log.Printf("Ignoring unexpected range result for instant query")
+			break
+		}
+		for _, stream := range v {
+			if len(stream.Values) == 0 {
+				continue
+			}
+			samples.add(stream.Metric, float64(stream.Values[len(stream.Values)-1].Value))
+		}
+	default:
+		log.Printf("Unexpected Prometheus result type %s", result.Type())
+	}
+
+	return samples.values
+}
+
+// parseNodeAverages extracts the mean of each series per node from a range
+// query result so a single spike inside the window doesn't dominate.
+func parseNodeAverages(result model.Value, quirks backendQuirks) map[string][]float64 {
+	matrix, ok := result.(model.Matrix)
+	if !ok {
+		// Some backends answer short windows with an instant vector
+		return parseNodeValues(result, quirks)
+	}
+
+	samples := newNodeSamples(quirks)
+	for _, stream := range matrix {
+		if len(stream.Values) == 0 {
+			continue
+		}
+
+		sum := 0.0
+		for _, point := range stream.Values {
+			sum += float64(point.Value)
+		}
+		samples.add(stream.Metric, sum/float64(len(stream.Values)))
+	}
+
+	return samples.values
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// decodeResult decodes the data section of a query API response.
+func decodeResult(t *testing.T, payload string) model.Value {
+	t.Helper()
+	var data struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatalf("decoding payload: %v", err)
+	}
+	var result model.Value
+	switch data.ResultType {
+	case "vector":
+		result = &model.Vector{}
+	case "matrix":
+		result = &model.Matrix{}
+	default:
+		t.Fatalf("unexpected result type %s", data.ResultType)
+	}
+	if err := json.Unmarshal(data.Result, result); err != nil {
+		t.Fatalf("decoding %s: %v", data.ResultType, err)
+	}
+	switch v := result.(type) {
+	case *model.Vector:
+		return *v
+	case *model.Matrix:
+		return *v
+	}
+	return nil
+}
+
+func TestParseThanosResult(t *testing.T) {
+	// Deduplication off: both replicas answer, and the federated series
+	// carries exported_node
+	payload := `{"resultType":"vector","result":[
+		{"metric":{"__name__":"ebpf_rtt_p99_milliseconds","node":"a","replica":"0"},"value":[1760000000,"12"]},
+		{"metric":{"__name__":"ebpf_rtt_p99_milliseconds","node":"a","replica":"1"},"value":[1760000000,"13"]},
+		{"metric":{"__name__":"ebpf_rtt_p99_milliseconds","exported_node":"b","prometheus_replica":"x"},"value":[1760000000,"40"]}
+	]}`
+	values := parseNodeValues(decodeResult(t, payload), quirksFor("thanos"))
+
+	if got := values["a"]; len(got) != 1 || got[0] != 12 {
+		t.Errorf("node a = %v, want the first replica only", got)
+	}
+	if got := values["b"]; len(got) != 1 || got[0] != 40 {
+		t.Errorf("node b = %v, want its exported_node series", got)
+	}
+
+	// Plain Prometheus has no replicas to fold and no exported_node
+	values = parseNodeValues(decodeResult(t, payload), quirksFor("prometheus"))
+	if len(values["a"]) != 2 || len(values["b"]) != 0 {
+		t.Errorf("prometheus quirks applied Thanos handling: %v", values)
+	}
+}
+
+func TestParseVictoriaMetricsResult(t *testing.T) {
+	// An instant query answered with a range result
+	payload := `{"resultType":"matrix","result":[
+		{"metric":{"node":"a"},"values":[[1760000000,"30"],[1760000030,"35"]]},
+		{"metric":{"node":"b"},"values":[]}
+	]}`
+	values := parseNodeValues(decodeResult(t, payload), quirksFor("VictoriaMetrics"))
+	if got := values["a"]; len(got) != 1 || got[0] != 35 {
+		t.Errorf("node a = %v, want the newest point", got)
+	}
+	if _, ok := values["b"]; ok {
+		t.Errorf("empty series produced a value: %v", values["b"])
+	}
+
+	if values := parseNodeValues(decodeResult(t, payload), quirksFor("prometheus")); len(values) != 0 {
+		t.Errorf("prometheus accepted a range result for an instant query: %v", values)
+	}
+}
+
+func TestParseMimirResult(t *testing.T) {
+	payload := `{"resultType":"vector","result":[
+		{"metric":{"node":"a","__replica__":"r1"},"value":[1760000000,"7"]},
+		{"metric":{"node":"a","__replica__":"r2"},"value":[1760000000,"7"]},
+		{"metric":{"node":"a","cpu":"1","__replica__":"r1"},"value":[1760000000,"9"]}
+	]}`
+	values := parseNodeValues(decodeResult(t, payload), quirksFor("mimir"))
+	if got := values["a"]; len(got) != 2 {
+		t.Errorf("node a = %v, want one value per distinct series", got)
+	}
+}
+
+func TestAPIURL(t *testing.T) {
+	tests := []struct{ url, backend, want string }{
+		{"http://vm:8428", "victoriametrics", "http://vm:8428/prometheus"},
+		{"http://vm:8481/select/0/prometheus", "victoriametrics", "http://vm:8481/select/0/prometheus"},
+		{"http://prometheus:9090", "prometheus", "http://prometheus:9090"},
+		{"http://thanos:9090", "thanos", "http://thanos:9090"},
+	}
+	for _, tt := range tests {
+		got, err := apiURL(tt.url, tt.backend)
+		if err != nil || got != tt.want {
+			t.Errorf("apiURL(%q, %q) = %q, %v; want %q", tt.url, tt.backend, got, err, tt.want)
+		}
+	}
+}
+
+func TestSmoothingWindowAveragesSpike(t *testing.T) {
+	var rangeQueries atomic.Int32
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(r.Form.Get("query"), "ebpf_rtt_p99_milliseconds") {
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[]}}`)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "query_range") {
+			// The instant value happens to land on the spike
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"node":"a"},"value":[1760000240,"500"]}]}}`)
+			return
+		}
+		rangeQueries.Add(1)
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"node":"a"},"values":[[1760000000,"20"],[1760000060,"20"],[1760000120,"500"],[1760000180,"20"],[1760000240,"20"]]}]}}`)
+	}))
+	defer prom.Close()
+
+	refresh := func(window string) float64 {
+		t.Helper()
+		cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.SmoothingWindow = window })
+		se := newTestExtender(t, cfg, nil)
+		client, _ := api.NewClient(api.Config{Address: prom.URL})
+		se.promClient = v1.NewAPI(client)
+		if err := se.updateMetrics(context.Background(), cfg); err != nil {
+			t.Fatalf("updateMetrics: %v", err)
+		}
+		return se.metricsCache["a"].RTTp99
+	}
+
+	if got := refresh("5m"); got != 116 {
+		t.Errorf("smoothed RTT = %v, want the window average 116", got)
+	}
+	if rangeQueries.Load() == 0 {
+		t.Error("no range query issued with a smoothing window")
+	}
+
+	rangeQueries.Store(0)
+	if got := refresh(""); got != 500 {
+		t.Errorf("instant RTT = %v, want 500", got)
+	}
+	if n := rangeQueries.Load(); n != 0 {
+		t.Errorf("%d range queries issued without a smoothing window", n)
+	}
+}
+
+func TestVictoriaMetricsClientPathPrefix(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	prom := fakePrometheus(t, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 10}})
+	vm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		prom.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer vm.Close()
+
+	t.Setenv("PROMETHEUS_URL", vm.URL)
+	t.Setenv("METRICS_BACKEND", "victoriametrics")
+	t.Setenv("DEBUG", "false")
+	se, err := NewSchedulerExtender()
+	if err != nil {
+		t.Fatalf("NewSchedulerExtender: %v", err)
+	}
+	if err := se.updateMetrics(context.Background(), se.config.Load()); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+
+	if len(paths) == 0 {
+		t.Fatal("no requests reached VictoriaMetrics")
+	}
+	for _, path := range paths {
+		if path != "/prometheus/api/v1/query" {
+			t.Errorf("request to %s, want /prometheus/api/v1/query", path)
+		}
+	}
+	if got := se.metricsCache["a"]; got == nil || got.RTTp99 != 10 {
+		t.Errorf("node a = %+v, want RTT 10 parsed from VictoriaMetrics", got)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// clearCacheHandler empties the metrics cache and marks it expired, so
+// the next request or background tick pulls everything fresh. The refresh
+// floor still applies.
+func (se *SchedulerExtender) clearCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validToken(r, se.config.Load().ReloadToken) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	se.mu.Lock()
+	cleared := len(se.metricsCache)
+	se.metricsCache = make(map[string]*NodeMetrics)
+	se.lastUpdate = time.Time{}
+	se.generation++
+	se.mu.Unlock()
+
+	log.Printf("Cleared %d entries from the metrics cache", cleared)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"cleared": cleared})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestClearCache(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.ReloadToken = "secret" })
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 20}, &NodeMetrics{NodeName: "b", RTTp99: 40})
+
+	if rec := postWithToken(t, se.clearCacheHandler, "/cache/clear", "wrong"); rec.Code != http.StatusForbidden {
+		t.Errorf("bad token: status %d, want 403", rec.Code)
+	}
+	if nodes, _ := getMetrics(t, se, ""); len(nodes) != 2 {
+		t.Fatalf("cache holds %d nodes after a rejected clear, want 2", len(nodes))
+	}
+
+	rec := postWithToken(t, se.clearCacheHandler, "/cache/clear", "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("clear: status %d: %s", rec.Code, rec.Body)
+	}
+	var body map[string]int
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding clear response: %v", err)
+	}
+	if body["cleared"] != 2 {
+		t.Errorf("cleared %d entries, want 2", body["cleared"])
+	}
+	if nodes, _ := getMetrics(t, se, ""); len(nodes) != 0 {
+		t.Errorf("cache holds %v after clearing", nodes)
+	}
+	if !se.cacheExpired(cfg) {
+		t.Error("cleared cache not expired, the next request won't refresh")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Clock abstracts the time source used for TTL and staleness decisions.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+// Now returns the current time including its monotonic reading, so
+// durations between two readings ignore wall-clock corrections.
+func (realClock) Now() time.Time { return time.Now() }
+
+// Policies for a timestamp that appears to be in the future, which only
+// happens for wall-clock-only times (e.g. a cache file's mtime) after the
+// clock stepped backwards.
+const (
+	clockSkewRefresh = "refresh" // treat the data as expired
+	clockSkewTrust   = "trust"   // treat the data as just fetched
+)
+
+// since returns how long ago t was according to the extender's clock.
+func (se *SchedulerExtender) since(cfg *ExtenderConfig, t time.Time) time.Duration {
+	age := se.clock.Now().Sub(t)
+	if age >= 0 {
+		return age
+	}
+
+	if cfg.ClockSkewPolicy == clockSkewTrust {
+		return 0
+	}
+	return time.Duration(math.MaxInt64)
+}
+
+// entryAge returns how long ago metrics was fetched. Entries fetched by
+// this process are aged on the monotonic clock; restored ones only have
+// their wall-clock Timestamp, subject to the skew policy.
+func (se *SchedulerExtender) entryAge(cfg *ExtenderConfig, metrics *NodeMetrics) time.Duration {
+	if !metrics.fetchedAt.IsZero() {
+		return se.since(cfg, metrics.fetchedAt)
+	}
+	return se.since(cfg, time.Unix(metrics.Timestamp, 0))
+}
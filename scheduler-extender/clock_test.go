@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStaleEntryScoresNeutral(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.MaxMetricAge = 60 })
+	se := newTestExtender(t, cfg, nil)
+	now := time.Now()
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "old", RTTp99: 5, Timestamp: now.Add(-10 * time.Minute).Unix()},
+		&NodeMetrics{NodeName: "fresh", RTTp99: 5, Timestamp: now.Unix()},
+	)
+
+	if score := se.calculateNodeScore(cfg, "old", scoreOptions{}); score != cfg.NeutralScore {
+		t.Errorf("10-minute-old entry scored %v, want neutral %v", score, cfg.NeutralScore)
+	}
+	if !se.metricsCache["old"].Stale {
+		t.Error("old entry not marked stale")
+	}
+	if score := se.calculateNodeScore(cfg, "fresh", scoreOptions{}); score == cfg.NeutralScore {
+		t.Error("fresh entry scored neutral")
+	}
+}
+
+func TestEntryAgeUsesMonotonicFetchTime(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.MaxMetricAge = 60
+		cfg.MetricHalfLife = 30
+	})
+	se := newTestExtender(t, cfg, nil)
+
+	// Fetched just now, but the wall clock read an hour behind at the time
+	fetched := time.Now()
+	m := &NodeMetrics{NodeName: "a", RTTp99: 5, Timestamp: fetched.Add(-time.Hour).Unix()}
+	m.fetchedAt = fetched
+	se.seedCache(cfg, m)
+
+	if age := se.entryAge(cfg, m); age > time.Minute {
+		t.Errorf("entry fetched just now aged %v", age)
+	}
+	if ratio := se.freshnessRatio(cfg, m); ratio < 0.9 {
+		t.Errorf("freshness ratio %v for an entry fetched just now", ratio)
+	}
+	if score := se.calculateNodeScore(cfg, "a", scoreOptions{}); score == cfg.NeutralScore || m.Stale {
+		t.Errorf("entry fetched just now scored %v, stale %v", score, m.Stale)
+	}
+
+	// A restored entry only has its wall-clock timestamp to go on
+	restored := *m
+	restored.fetchedAt = time.Time{}
+	if age := se.entryAge(cfg, &restored); age < 59*time.Minute {
+		t.Errorf("restored entry aged %v, want about an hour", age)
+	}
+}
+
+func TestEntryAgeFutureTimestamp(t *testing.T) {
+	future := &NodeMetrics{Timestamp: time.Now().Add(time.Hour).Unix()}
+
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	if age := se.entryAge(cfg, future); age < time.Hour {
+		t.Errorf("refresh policy: future entry aged %v, want it treated as expired", age)
+	}
+
+	cfg = testConfig(t, func(cfg *ExtenderConfig) { cfg.ClockSkewPolicy = clockSkewTrust })
+	if age := se.entryAge(cfg, future); age != 0 {
+		t.Errorf("trust policy: future entry aged %v, want 0", age)
+	}
+}
+
+func TestCacheTTLAfterBackwardClockJump(t *testing.T) {
+	for _, policy := range []string{clockSkewRefresh, clockSkewTrust} {
+		t.Run(policy, func(t *testing.T) {
+			cfg := testConfig(t, func(cfg *ExtenderConfig) {
+				cfg.CacheTTL = 10
+				cfg.ClockSkewPolicy = policy
+			})
+			se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 10}})
+			clock := newFakeClock()
+			se.clock = clock
+			if err := se.refresh(context.Background()); err != nil {
+				t.Fatalf("first refresh: %v", err)
+			}
+			if se.cacheExpired(cfg) {
+				t.Fatal("cache expired right after a refresh")
+			}
+
+			// NTP steps the clock back an hour: the last update now looks
+			// like it's in the future
+			clock.advance(-time.Hour)
+			if got, want := se.cacheExpired(cfg), policy == clockSkewRefresh; got != want {
+				t.Errorf("expired = %v after the jump, want %v", got, want)
+			}
+
+			// Refreshing after the jump happens at most once, not on
+			// every request
+			generation := se.generation
+			for i := 0; i < 5; i++ {
+				if err := se.refresh(context.Background()); err != nil {
+					t.Fatalf("refresh after the jump: %v", err)
+				}
+			}
+			refreshes := se.generation - generation
+			if policy == clockSkewRefresh && refreshes != 1 {
+				t.Errorf("%d refreshes after the jump, want 1", refreshes)
+			}
+			if policy == clockSkewTrust && refreshes != 0 {
+				t.Errorf("%d refreshes after the jump, want the cache trusted", refreshes)
+			}
+
+			// Time moving forward normally expires the cache again
+			clock.advance(time.Hour + time.Minute)
+			if !se.cacheExpired(cfg) {
+				t.Error("cache never expires after the jump")
+			}
+		})
+	}
+}
+
+func TestHalfLifeBlendsTowardNeutral(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.MetricHalfLife = 60
+		cfg.MaxMetricAge = 600
+	})
+	se := newTestExtender(t, cfg, nil)
+	clock := newFakeClock()
+	se.clock = clock
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5})
+
+	full := se.calculateNodeScore(cfg, "a", scoreOptions{})
+	if full == cfg.NeutralScore {
+		t.Fatal("fresh entry scored neutral")
+	}
+
+	clock.advance(60 * time.Second)
+	halfway := cfg.NeutralScore + (full-cfg.NeutralScore)/2
+	if score := se.calculateNodeScore(cfg, "a", scoreOptions{}); math.Abs(score-halfway) > 1e-9 {
+		t.Errorf("half-life-old entry scored %v, want %v halfway between %v and neutral", score, halfway, full)
+	}
+
+	clock.advance(60 * time.Second)
+	quarter := cfg.NeutralScore + (full-cfg.NeutralScore)/4
+	if score := se.calculateNodeScore(cfg, "a", scoreOptions{}); math.Abs(score-quarter) > 1e-9 {
+		t.Errorf("entry two half-lives old scored %v, want %v", score, quarter)
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+// sampleConfidence returns how far to trust a node's value for a metric,
+// from 0 with no samples to 1 at MinSampleCount samples. Metrics without
+// a sample count are trusted fully.
+func sampleConfidence(cfg *ExtenderConfig, m *NodeMetrics, name string) float64 {
+	count, ok := m.SampleCounts[name]
+	if !ok || cfg.MinSampleCount <= 0 || count >= cfg.MinSampleCount {
+		return 1
+	}
+	return max(count, 0) / cfg.MinSampleCount
+}
@@ -0,0 +1,758 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	v1core "k8s.io/api/core/v1"
+)
+
+type ExtenderConfig struct {
+	PrometheusURL   string       `json:"prometheus_url"`
+	Weights         ScoreWeights `json:"weights"`
+	Port            int          `json:"port"`
+	Debug           bool         `json:"debug"`
+	CacheTTL        int          `json:"cache_ttl_seconds"`
+	CacheFile       string       `json:"cache_file"`
+	CacheMaxAge     int          `json:"cache_file_max_age_seconds"`
+	Backend         string       `json:"backend"`
+	SmoothingWindow string       `json:"smoothing_window"` // e.g. "5m"; empty uses instant queries
+	ClockSkewPolicy string       `json:"clock_skew_policy"`
+	// FilterThresholds fails nodes in filter whose metric is worse than
+	// the threshold, keyed by metric name (e.g. "fd_util": 95). They are
+	// independent of the weights, so a node can pass filter yet rank last.
+	// Custom metrics with a threshold are queried even when unweighted.
+	FilterThresholds map[string]float64 `json:"filter_thresholds"`
+	// WarmupObservations ramps a new node's score from neutral to its
+	// computed value over this many refreshes; 0 trusts nodes immediately
+	WarmupObservations int `json:"warmup_observations"`
+	// Aggregations overrides how duplicate series are combined per metric
+	// (sum, avg, max or median)
+	Aggregations map[string]string `json:"aggregations"`
+	// AccessLogFormat is combined, json or none
+	AccessLogFormat string `json:"access_log_format"`
+	// NeutralScore is given to nodes without metrics
+	NeutralScore float64 `json:"neutral_score"`
+	// PodScoreCache reuses rankings for pods with the same weight profile
+	// and CPU request until the metrics cache is refreshed
+	PodScoreCache bool `json:"pod_score_cache"`
+	// MaxQueriesPerSecond paces Prometheus queries; 0 disables the limit
+	MaxQueriesPerSecond float64 `json:"max_queries_per_second"`
+	// UnknownFields is ignore, log or reject; empty logs only in debug mode
+	UnknownFields string `json:"unknown_fields"`
+	// CPURequestReference is the CPU request (in cores) that doubles the
+	// cpu_util penalty; 0 ignores pod requests
+	CPURequestReference float64 `json:"cpu_request_reference_cores"`
+	// QueryLabels are label matchers added to every query, e.g. to scope
+	// a multi-tenant Prometheus with cluster="prod"
+	QueryLabels map[string]string `json:"query_labels"`
+	// MinRefreshInterval is a hard floor between refreshes in seconds
+	MinRefreshInterval int `json:"min_refresh_interval_seconds"`
+	// HistoryMaxEntries bounds the score history kept per node
+	HistoryMaxEntries int `json:"history_max_entries"`
+	// CanaryScoreCap is the highest score a canary-annotated node can get
+	CanaryScoreCap float64 `json:"canary_score_cap"`
+	// MaxMetricAge makes entries older than this many seconds score
+	// neutral; 0 disables the check
+	MaxMetricAge int `json:"max_metric_age_seconds"`
+	// DisruptionPenalty is subtracted from the score of cordoned or
+	// draining nodes
+	DisruptionPenalty float64 `json:"disruption_penalty"`
+	// FilterDisruptedNodes fails cordoned or draining nodes in filter
+	FilterDisruptedNodes bool `json:"filter_disrupted_nodes"`
+	// TracingEndpoint is an OTLP/HTTP collector (host:port); empty
+	// disables tracing
+	TracingEndpoint string `json:"tracing_endpoint"`
+	// TracingInsecure sends spans over plain HTTP
+	TracingInsecure bool `json:"tracing_insecure"`
+	// Profiles are named weight sets a pod selects with the
+	// ebpf-scheduler/profile annotation, each optionally listing metrics
+	// a node must have to be scored; set them in CONFIG_FILE
+	Profiles map[string]WeightProfile `json:"profiles"`
+	// ProfileSchedule switches pods that don't name a profile to one of
+	// Profiles during time windows, e.g. latency-heavy weights in business
+	// hours; set it in CONFIG_FILE. Outside all windows the base weights
+	// apply.
+	ProfileSchedule []ProfileWindow `json:"profile_schedule,omitempty"`
+	// NodeGroups maps a metric source reported in place of a node, such
+	// as a rack or switch, to the nodes behind it; members inherit its
+	// samples for metrics they don't report themselves. Set it in
+	// CONFIG_FILE.
+	NodeGroups map[string][]string `json:"node_groups,omitempty"`
+	// CustomMetrics are scored alongside the built-in metrics, each with
+	// its own query, weight and normalization bounds; set them in
+	// CONFIG_FILE
+	CustomMetrics []CustomMetric `json:"custom_metrics,omitempty"`
+	// DebugCacheMaxAge lets pollers of debug endpoints reuse a response
+	// for this many seconds; 0 makes them revalidate every time
+	DebugCacheMaxAge int `json:"debug_cache_max_age_seconds"`
+	// ShutdownGracePeriod bounds how long shutdown waits for in-flight
+	// requests and the background refresh, in seconds
+	ShutdownGracePeriod int `json:"shutdown_grace_period_seconds"`
+	// MeshProfile is the profile used for mesh-injected pods that don't
+	// name one themselves
+	MeshProfile string `json:"mesh_profile"`
+	// HedgeDelay starts a second copy of a Prometheus query that hasn't
+	// answered within this many milliseconds; 0 disables hedging
+	HedgeDelay int `json:"hedge_delay_ms"`
+	// Scorer names the model combining normalized metrics into a score
+	Scorer string `json:"scorer"`
+	// AnnotatePlacement annotates bound pods that carry the
+	// ebpf-scheduler/placement-quality readiness gate with their node's score
+	AnnotatePlacement bool `json:"annotate_placement"`
+	// PlacementQualityBar is the node score a placement must reach to be
+	// annotated as met
+	PlacementQualityBar float64 `json:"placement_quality_bar"`
+	// FilterExpression fails nodes in filter for which it holds, e.g.
+	// "drop_rate > 5 AND rtt_p99 > 200"; see filterExpr
+	FilterExpression string `json:"filter_expression"`
+	// MaxConcurrentRequests answers filter and prioritize with 429 beyond
+	// this many in-flight calls; 0 disables the limit
+	MaxConcurrentRequests int `json:"max_concurrent_requests"`
+	// MetricHalfLife decays a node's score toward neutral as its metrics
+	// age, halving the distance every this many seconds; 0 disables decay
+	MetricHalfLife int `json:"metric_half_life_seconds"`
+	// DisabledMetrics are neither queried, scored nor filtered on; the
+	// remaining weights are renormalized
+	DisabledMetrics []string `json:"disabled_metrics"`
+	// AllocatableFallback scores nodes without metrics by their allocatable
+	// CPU and memory instead of neutrally
+	AllocatableFallback bool `json:"allocatable_fallback"`
+	// PrometheusHeaders are added to every Prometheus request, e.g.
+	// X-Scope-OrgID for a multi-tenant proxy
+	PrometheusHeaders map[string]string `json:"prometheus_headers"`
+	// MinFilterCoverage is the percentage of candidate nodes that must
+	// have metrics for filter to apply metric checks; 0 always applies them
+	MinFilterCoverage float64 `json:"min_filter_coverage_percent"`
+	// MetricScaling selects linear (default) or log normalization per
+	// metric; log spreads out the low end of metrics spanning magnitudes
+	MetricScaling map[string]string `json:"metric_scaling"`
+	// ZoneBonus is added to the score of nodes in the zone a pod prefers
+	// through the ebpf-scheduler/preferred-zone annotation
+	ZoneBonus float64 `json:"zone_bonus"`
+	// SpreadPenalty is subtracted, in proportion, from nodes already running
+	// siblings of the pod (pods of the same workload); the node with the most
+	// siblings loses all of it. Zero disables the pod watch this needs.
+	SpreadPenalty float64 `json:"spread_penalty"`
+	// QueryTimeoutSeconds bounds all Prometheus queries of one refresh
+	QueryTimeoutSeconds int `json:"query_timeout_seconds"`
+	// ScoreJitter, when set, adds up to this many points to nodes tied on
+	// score, varied per pod, so equally good nodes share the load. It never
+	// lifts a node to or past the next higher score. Values between 0 and 1
+	// are rejected since whole-point scores can't carry them.
+	ScoreJitter float64 `json:"score_jitter"`
+	// PrometheusTokenFile holds a bearer token sent with every Prometheus
+	// request; the file is re-read every minute so rotated tokens apply
+	PrometheusTokenFile string `json:"prometheus_token_file"`
+	// ScoreControlPlane lets filter pass control-plane nodes, which are
+	// otherwise failed so workload pods stay off them
+	ScoreControlPlane bool `json:"score_control_plane"`
+	// TrendPenalty is taken off nodes whose weighted metrics are getting
+	// worse, in full when every metric worsened by its whole normalization
+	// range over TrendWindow refreshes; 0 disables it
+	TrendPenalty float64 `json:"trend_penalty"`
+	// TrendWindow is how many refreshes a metric trend is fitted over
+	TrendWindow int `json:"trend_window"`
+	// MissingValues decides, per metric, how a node without a value for it
+	// is scored: neutral, best or worst. Unlisted metrics score as zero.
+	MissingValues map[string]string `json:"missing_values"`
+	// Ignorable mirrors the extender's ignorable flag in the scheduler
+	// config. Errors reported to an ignorable extender's scheduler are only
+	// logged; otherwise they fail scheduling, so filter then reports just
+	// total metric loss and stays silent about transient failures.
+	Ignorable bool `json:"ignorable"`
+	// InjectEnabled turns on POST /inject, which writes metrics straight into
+	// the cache for end-to-end tests. Never enable it in production.
+	InjectEnabled bool `json:"inject_enabled"`
+	// FailOnEmptyCache makes prioritize answer 503 instead of neutral scores
+	// while the metrics cache is empty and expired, so the scheduler treats
+	// the extender as failed (or ignores it, if ignorable)
+	FailOnEmptyCache bool `json:"fail_on_empty_cache"`
+	// ScoreEWMAAlpha smooths node scores across refreshes: each refresh moves
+	// a node's score this fraction of the way to its new value. 1 disables
+	// smoothing.
+	ScoreEWMAAlpha float64 `json:"score_ewma_alpha"`
+	// SchedulerAddr is where /filter, /prioritize, /preempt and the admin
+	// endpoints listen. Empty means ":<port>".
+	SchedulerAddr string `json:"scheduler_addr"`
+	// MetricsAddr, if set, moves the observability endpoints (/metrics,
+	// /prom-metrics, /health, /readyz, /verify, /history, /worst, /config,
+	// /snapshot) to a separate listener.
+	MetricsAddr string `json:"metrics_addr"`
+	// AllowNegativeWeights accepts negative weights. A negative weight scores
+	// its metric in reverse, so nodes doing well on it rank lower.
+	AllowNegativeWeights bool `json:"allow_negative_weights"`
+	// WarmupPeriod is how many seconds after startup prioritize scores every
+	// node neutral, so a partial first view of the cluster isn't acted on
+	WarmupPeriod int `json:"warmup_period_seconds"`
+	// SampleCountQueries are companion queries returning, per node, how many
+	// samples a metric's value is based on, keyed by metric name. Metrics
+	// backed by fewer than MinSampleCount samples are scored closer to
+	// neutral. Set them in CONFIG_FILE.
+	SampleCountQueries map[string]string `json:"sample_count_queries,omitempty"`
+	// MinSampleCount is the sample count at which a metric with a sample
+	// count query is fully trusted
+	MinSampleCount float64 `json:"min_sample_count"`
+	// FailOnQueryWarnings treats a query Prometheus answered with warnings,
+	// which often mean partial results, as failed instead of scoring on it
+	FailOnQueryWarnings bool `json:"fail_on_query_warnings"`
+	// QoSProfiles maps a pod QoS class (Guaranteed, Burstable, BestEffort)
+	// to the profile for pods of that class that don't name a profile and
+	// aren't meshed, e.g. "Guaranteed=latency". Unmapped classes use the
+	// base weights.
+	QoSProfiles map[string]string `json:"qos_profiles,omitempty"`
+	// FallbackQueries are raw queries, keyed by metric name, run when the
+	// metric's own query (usually a recording rule) returns nothing, e.g.
+	// histogram_quantile over the raw histogram when the rule isn't
+	// deployed. Set them in CONFIG_FILE.
+	FallbackQueries map[string]string `json:"fallback_queries,omitempty"`
+	// DedupeWindow is how many seconds prioritize answers a repeat of a
+	// request (same pod, pod version and candidate nodes) with the response
+	// it already gave, absorbing scheduler retries; 0 disables it
+	DedupeWindow int `json:"dedupe_window_seconds"`
+	// ConfigFile is a JSON file overlaid on the environment settings
+	ConfigFile string `json:"config_file"`
+	// ReloadToken guards POST /reload, /drain, /undrain, /inject and
+	// /cache/clear, which are disabled when it is empty
+	ReloadToken string `json:"reload_token"`
+
+	// filterExpr is FilterExpression compiled
+	filterExpr *filterExpr
+	// disabledMetrics indexes DisabledMetrics
+	disabledMetrics map[string]bool
+
+	// loadedAt is when this configuration was loaded
+	loadedAt time.Time
+
+	// version hashes the settings above so results computed under one
+	// configuration are never reused under another
+	version string
+}
+
+// loadConfig builds the configuration from the environment, overlays
+// CONFIG_FILE if set, and validates the result.
+func loadConfig() (*ExtenderConfig, error) {
+	config := configFromEnv(os.Getenv)
+
+	if config.ConfigFile != "" {
+		data, err := os.ReadFile(config.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", config.ConfigFile, err)
+		}
+	}
+
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	config.version = configVersion(config)
+	config.loadedAt = time.Now()
+	return config, nil
+}
+
+// defaultConfig returns the built-in settings without consulting the
+// environment.
+func defaultConfig() *ExtenderConfig {
+	return configFromEnv(func(string) string { return "" })
+}
+
+// configFromEnv builds the configuration from env, falling back to the
+// built-in default for every unset variable.
+func configFromEnv(env envLookup) *ExtenderConfig {
+	return &ExtenderConfig{
+		PrometheusURL:         env.str("PROMETHEUS_URL", "http://prometheus.monitoring:9090"),
+		Port:                  env.int("PORT", 8080),
+		Debug:                 env.bool("DEBUG", true),
+		CacheTTL:              env.int("CACHE_TTL", 10),
+		CacheFile:             env.str("CACHE_FILE", ""),
+		CacheMaxAge:           env.int("CACHE_FILE_MAX_AGE", 300),
+		Backend:               env.str("METRICS_BACKEND", "prometheus"),
+		SmoothingWindow:       env.str("SMOOTHING_WINDOW", ""),
+		ClockSkewPolicy:       env.str("CLOCK_SKEW_POLICY", clockSkewRefresh),
+		FilterThresholds:      env.floatMap("FILTER_THRESHOLDS"),
+		WarmupObservations:    env.int("WARMUP_OBSERVATIONS", 0),
+		Aggregations:          env.stringMap("AGGREGATIONS"),
+		AccessLogFormat:       env.str("ACCESS_LOG_FORMAT", accessLogNone),
+		NeutralScore:          env.float("NEUTRAL_SCORE", defaultNeutralScore),
+		PodScoreCache:         env.bool("POD_SCORE_CACHE", false),
+		MaxQueriesPerSecond:   env.float("MAX_QUERIES_PER_SECOND", 0),
+		UnknownFields:         env.str("UNKNOWN_FIELDS", ""),
+		CPURequestReference:   env.float("CPU_REQUEST_REFERENCE_CORES", 1),
+		QueryLabels:           env.stringMap("QUERY_LABELS"),
+		MinRefreshInterval:    env.int("MIN_REFRESH_INTERVAL", 1),
+		HistoryMaxEntries:     env.int("HISTORY_MAX_ENTRIES", 60),
+		CanaryScoreCap:        env.float("CANARY_SCORE_CAP", 25),
+		MaxMetricAge:          env.int("MAX_METRIC_AGE", 60),
+		DisruptionPenalty:     env.float("DISRUPTION_PENALTY", 50),
+		FilterDisruptedNodes:  env.bool("FILTER_DISRUPTED_NODES", false),
+		TracingEndpoint:       env.str("OTLP_ENDPOINT", ""),
+		TracingInsecure:       env.bool("OTLP_INSECURE", false),
+		DebugCacheMaxAge:      env.int("DEBUG_CACHE_MAX_AGE", 0),
+		ShutdownGracePeriod:   env.int("SHUTDOWN_GRACE_PERIOD", 10),
+		MeshProfile:           env.str("MESH_PROFILE", "mesh"),
+		HedgeDelay:            env.int("HEDGE_DELAY_MS", 0),
+		Scorer:                env.str("SCORER", scorerWeighted),
+		AnnotatePlacement:     env.bool("ANNOTATE_PLACEMENT", false),
+		PlacementQualityBar:   env.float("PLACEMENT_QUALITY_BAR", defaultNeutralScore),
+		FilterExpression:      env.str("FILTER_EXPRESSION", ""),
+		MaxConcurrentRequests: env.int("MAX_CONCURRENT_REQUESTS", 0),
+		MetricHalfLife:        env.int("METRIC_HALF_LIFE", 0),
+		DisabledMetrics:       env.list("DISABLED_METRICS"),
+		AllocatableFallback:   env.bool("ALLOCATABLE_FALLBACK", false),
+		PrometheusHeaders:     env.stringMap("PROMETHEUS_HEADERS"),
+		MinFilterCoverage:     env.float("MIN_FILTER_COVERAGE", 0),
+		MetricScaling:         env.stringMap("METRIC_SCALING"),
+		ZoneBonus:             env.float("ZONE_BONUS", 10),
+		SpreadPenalty:         env.float("SPREAD_PENALTY", 0),
+		QueryTimeoutSeconds:   env.int("QUERY_TIMEOUT_SECONDS", 5),
+		ScoreJitter:           env.float("SCORE_JITTER", 0),
+		PrometheusTokenFile:   env.str("PROMETHEUS_TOKEN_FILE", ""),
+		ScoreControlPlane:     env.bool("SCORE_CONTROL_PLANE", false),
+		TrendPenalty:          env.float("TREND_PENALTY", 0),
+		TrendWindow:           env.int("TREND_WINDOW", 5),
+		MissingValues:         env.stringMap("MISSING_VALUES"),
+		Ignorable:             env.bool("IGNORABLE", true),
+		InjectEnabled:         env.bool("ENABLE_INJECT", false),
+		FailOnEmptyCache:      env.bool("FAIL_ON_EMPTY_CACHE", false),
+		ScoreEWMAAlpha:        env.float("SCORE_EWMA_ALPHA", 1),
+		SchedulerAddr:         env.str("SCHEDULER_ADDR", ""),
+		MetricsAddr:           env.str("METRICS_ADDR", ""),
+		AllowNegativeWeights:  env.bool("ALLOW_NEGATIVE_WEIGHTS", false),
+		WarmupPeriod:          env.int("WARMUP_PERIOD_SECONDS", 0),
+		MinSampleCount:        env.float("MIN_SAMPLE_COUNT", 10),
+		FailOnQueryWarnings:   env.bool("FAIL_ON_QUERY_WARNINGS", false),
+		QoSProfiles:           env.stringMap("QOS_PROFILES"),
+		DedupeWindow:          env.int("DEDUPE_WINDOW_SECONDS", 0),
+		ConfigFile:            env.str("CONFIG_FILE", ""),
+		ReloadToken:           env.str("RELOAD_TOKEN", ""),
+		Weights: ScoreWeights{
+			RTTp99:                  env.float("WEIGHT_RTT_P99", 0.3),
+			RetransRate:             env.float("WEIGHT_RETRANS_RATE", 0.2),
+			DropRate:                env.float("WEIGHT_DROP_RATE", 0.2),
+			RunqlatP95:              env.float("WEIGHT_RUNQLAT_P95", 0.15),
+			CPUUtil:                 env.float("WEIGHT_CPU_UTIL", 0.15),
+			ZeroWindowRate:          env.float("WEIGHT_ZERO_WINDOW_RATE", 0),
+			PacketProcP99:           env.float("WEIGHT_PACKET_PROC_P99", 0),
+			MemPressure:             env.float("WEIGHT_MEM_PRESSURE", 0),
+			FDUtil:                  env.float("WEIGHT_FD_UTIL", 0),
+			Bufferbloat:             env.float("WEIGHT_BUFFERBLOAT", 0),
+			EBPFProgramCPU:          env.float("WEIGHT_EBPF_PROGRAM_CPU", 0),
+			ConnEstablishLatencyP95: env.float("WEIGHT_CONN_ESTABLISH_LATENCY_P95", 0),
+			RTORate:                 env.float("WEIGHT_RTO_RATE", 0),
+			SocketBufferPressure:    env.float("WEIGHT_SOCKET_BUFFER_PRESSURE", 0),
+			RouteChangesRate:        env.float("WEIGHT_ROUTE_CHANGES_RATE", 0),
+			MPTCPSubflowHealth:      env.float("WEIGHT_MPTCP_SUBFLOW_HEALTH", 0),
+			SidecarLatencyP95:       env.float("WEIGHT_SIDECAR_LATENCY_P95", 0),
+			CwndCollapseRate:        env.float("WEIGHT_CWND_COLLAPSE_RATE", 0),
+			AQMScore:                env.float("WEIGHT_AQM_SCORE", 0),
+			RetransConnections:      env.float("WEIGHT_RETRANS_CONNECTIONS", 0),
+		},
+	}
+}
+
+func validateConfig(config *ExtenderConfig) error {
+	if _, ok := backendQuirksByName[strings.ToLower(config.Backend)]; !ok {
+		log.Printf("Unknown metrics backend %q, assuming prometheus", config.Backend)
+	}
+
+	if config.SmoothingWindow != "" {
+		if _, err := time.ParseDuration(config.SmoothingWindow); err != nil {
+			return fmt.Errorf("invalid smoothing window %q: %w", config.SmoothingWindow, err)
+		}
+	}
+
+	if config.ClockSkewPolicy != clockSkewRefresh && config.ClockSkewPolicy != clockSkewTrust {
+		return fmt.Errorf("invalid clock skew policy %q: must be %q or %q", config.ClockSkewPolicy, clockSkewRefresh, clockSkewTrust)
+	}
+
+	if err := validateCustomMetrics(config.CustomMetrics, config.AllowNegativeWeights); err != nil {
+		return err
+	}
+	for name := range config.FilterThresholds {
+		if !config.knownMetric(name) {
+			return fmt.Errorf("filter threshold for unknown metric %q", name)
+		}
+	}
+
+	for name, agg := range config.Aggregations {
+		if !config.knownMetric(name) {
+			return fmt.Errorf("aggregation for unknown metric %q", name)
+		}
+		if !validAggregation(agg) {
+			return fmt.Errorf("invalid aggregation %q for %s", agg, name)
+		}
+	}
+
+	if !validAccessLogFormat(config.AccessLogFormat) {
+		return fmt.Errorf("invalid access log format %q", config.AccessLogFormat)
+	}
+
+	if config.NeutralScore < minScore || config.NeutralScore > maxScore {
+		return fmt.Errorf("neutral score %.2f outside score range %.0f-%.0f", config.NeutralScore, minScore, maxScore)
+	}
+
+	if config.MaxQueriesPerSecond < 0 {
+		return fmt.Errorf("max queries per second must not be negative")
+	}
+
+	if !validUnknownFieldPolicy(config.UnknownFields) {
+		return fmt.Errorf("invalid unknown fields policy %q", config.UnknownFields)
+	}
+
+	if config.MinRefreshInterval < 0 {
+		return fmt.Errorf("min refresh interval must not be negative")
+	}
+
+	if config.CanaryScoreCap < minScore || config.CanaryScoreCap > maxScore {
+		return fmt.Errorf("canary score cap %.2f outside score range %.0f-%.0f", config.CanaryScoreCap, minScore, maxScore)
+	}
+
+	if _, ok := scorers[config.Scorer]; !ok {
+		return fmt.Errorf("unknown scorer %q", config.Scorer)
+	}
+
+	if config.PlacementQualityBar < minScore || config.PlacementQualityBar > maxScore {
+		return fmt.Errorf("placement quality bar %.2f outside score range %.0f-%.0f", config.PlacementQualityBar, minScore, maxScore)
+	}
+
+	config.disabledMetrics = make(map[string]bool, len(config.DisabledMetrics))
+	for _, name := range config.DisabledMetrics {
+		if !config.knownMetric(name) {
+			return fmt.Errorf("cannot disable unknown metric %q", name)
+		}
+		config.disabledMetrics[name] = true
+	}
+
+	if config.FilterExpression != "" {
+		expr, err := compileFilterExpr(config.FilterExpression)
+		if err != nil {
+			return fmt.Errorf("invalid filter expression %q: %w", config.FilterExpression, err)
+		}
+		config.filterExpr = expr
+	}
+
+	if config.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("max concurrent requests must not be negative")
+	}
+
+	if config.MetricHalfLife < 0 {
+		return fmt.Errorf("metric half-life must not be negative")
+	}
+
+	for name, treatment := range config.MissingValues {
+		if !config.knownMetric(name) {
+			return fmt.Errorf("missing value treatment for unknown metric %q", name)
+		}
+		if treatment != missingNeutral && treatment != missingBest && treatment != missingWorst {
+			return fmt.Errorf("invalid missing value treatment %q for %s: must be %q, %q or %q",
+				treatment, name, missingNeutral, missingBest, missingWorst)
+		}
+	}
+
+	for name, query := range config.FallbackQueries {
+		if !config.knownMetric(name) {
+			return fmt.Errorf("fallback query for unknown metric %q", name)
+		}
+		if query == "" {
+			return fmt.Errorf("empty fallback query for %s", name)
+		}
+	}
+	for name, query := range config.SampleCountQueries {
+		if !config.knownMetric(name) {
+			return fmt.Errorf("sample count query for unknown metric %q", name)
+		}
+		if query == "" {
+			return fmt.Errorf("empty sample count query for %s", name)
+		}
+	}
+	if config.MinSampleCount < 0 {
+		return fmt.Errorf("min sample count must not be negative")
+	}
+
+	for class, profile := range config.QoSProfiles {
+		switch v1core.PodQOSClass(class) {
+		case v1core.PodQOSGuaranteed, v1core.PodQOSBurstable, v1core.PodQOSBestEffort:
+		default:
+			return fmt.Errorf("qos profile for unknown QoS class %q", class)
+		}
+		if _, ok := config.Profiles[profile]; !ok {
+			return fmt.Errorf("qos profile for %s names unknown profile %q", class, profile)
+		}
+	}
+
+	for name, scaling := range config.MetricScaling {
+		if !config.knownMetric(name) {
+			return fmt.Errorf("scaling for unknown metric %q", name)
+		}
+		if scaling != scalingLinear && scaling != scalingLog {
+			return fmt.Errorf("invalid scaling %q for %s: must be %q or %q", scaling, name, scalingLinear, scalingLog)
+		}
+	}
+
+	if config.MinFilterCoverage < 0 || config.MinFilterCoverage > 100 {
+		return fmt.Errorf("min filter coverage %.2f outside 0-100", config.MinFilterCoverage)
+	}
+
+	if config.ZoneBonus < 0 || config.ZoneBonus > maxScore {
+		return fmt.Errorf("zone bonus %.2f outside 0-%.0f", config.ZoneBonus, maxScore)
+	}
+	if config.SpreadPenalty < 0 || config.SpreadPenalty > maxScore {
+		return fmt.Errorf("spread penalty %.2f outside 0-%.0f", config.SpreadPenalty, maxScore)
+	}
+	if config.QueryTimeoutSeconds <= 0 {
+		return fmt.Errorf("query timeout must be positive")
+	}
+	if config.ScoreJitter < 0 || config.ScoreJitter > maxScore {
+		return fmt.Errorf("score jitter %.2f outside 0-%.0f", config.ScoreJitter, maxScore)
+	}
+	// Jitter is rounded to whole points, so below one it never breaks a tie
+	if config.ScoreJitter > 0 && config.ScoreJitter < 1 {
+		return fmt.Errorf("score jitter %.2f must be 0 or at least 1", config.ScoreJitter)
+	}
+	if config.TrendPenalty < 0 || config.TrendPenalty > maxScore {
+		return fmt.Errorf("trend penalty %.2f outside 0-%.0f", config.TrendPenalty, maxScore)
+	}
+	if config.TrendWindow < 2 {
+		return fmt.Errorf("trend window must cover at least 2 refreshes")
+	}
+	if config.ScoreEWMAAlpha <= 0 || config.ScoreEWMAAlpha > 1 {
+		return fmt.Errorf("score EWMA alpha %.2f outside (0, 1]", config.ScoreEWMAAlpha)
+	}
+	if config.SchedulerAddr == "" {
+		config.SchedulerAddr = fmt.Sprintf(":%d", config.Port)
+	}
+	if config.MetricsAddr != "" && config.MetricsAddr == config.SchedulerAddr {
+		return fmt.Errorf("metrics address %s is also the scheduler address", config.MetricsAddr)
+	}
+	if config.WarmupPeriod < 0 {
+		return fmt.Errorf("warmup period must not be negative")
+	}
+	if config.DedupeWindow < 0 {
+		return fmt.Errorf("dedupe window must not be negative")
+	}
+	if config.ShutdownGracePeriod < 0 {
+		return fmt.Errorf("shutdown grace period must not be negative")
+	}
+
+	if err := validateWeights(&config.Weights, config.AllowNegativeWeights); err != nil {
+		return err
+	}
+	for name, profile := range config.Profiles {
+		if err := validateWeights(&profile.ScoreWeights, config.AllowNegativeWeights); err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+		for _, metric := range profile.RequiredMetrics {
+			if lookupMetricSpec(metric) == nil {
+				return fmt.Errorf("profile %s requires unknown metric %q", name, metric)
+			}
+		}
+	}
+	if err := validateNodeGroups(config.NodeGroups); err != nil {
+		return err
+	}
+	for i := range config.ProfileSchedule {
+		if err := config.ProfileSchedule[i].parse(config.Profiles); err != nil {
+			return fmt.Errorf("profile schedule window %d: %w", i, err)
+		}
+	}
+
+	if config.DisruptionPenalty < 0 || config.DisruptionPenalty > maxScore {
+		return fmt.Errorf("disruption penalty %.2f outside 0-%.0f", config.DisruptionPenalty, maxScore)
+	}
+
+	return nil
+}
+
+// validateWeights rejects non-finite weights and, unless allowNegative is
+// set, negative ones, which are easy to set by mistake.
+func validateWeights(weights *ScoreWeights, allowNegative bool) error {
+	for _, spec := range metricSpecs {
+		if w := *spec.weight(weights); (w < 0 && !allowNegative) || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("weight for %s must be a non-negative number, got %v", spec.name, w)
+		}
+	}
+	return nil
+}
+
+// queryRateLimit converts MaxQueriesPerSecond into a limiter rate.
+func queryRateLimit(config *ExtenderConfig) rate.Limit {
+	if config.MaxQueriesPerSecond <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(config.MaxQueriesPerSecond)
+}
+
+// reloadHandler re-reads the environment and config file and atomically
+// swaps in the new configuration. In-flight requests finish on the
+// snapshot they started with.
+func (se *SchedulerExtender) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current := se.config.Load()
+	if !validToken(r, current.ReloadToken) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	next, err := loadConfig()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reload config: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// These are wired up once at startup
+	if next.PrometheusURL != current.PrometheusURL || next.Port != current.Port ||
+		next.SchedulerAddr != current.SchedulerAddr || next.MetricsAddr != current.MetricsAddr ||
+		next.HistoryMaxEntries != current.HistoryMaxEntries || next.CacheFile != current.CacheFile ||
+		next.TracingEndpoint != current.TracingEndpoint || next.TracingInsecure != current.TracingInsecure {
+		log.Printf("Warning: prometheus_url, port, listen addresses, history_max_entries, cache_file and tracing changes require a restart")
+	}
+
+	if next.SpreadPenalty > 0 && se.kubeClient != nil && se.podLister == nil {
+		log.Printf("Warning: spread_penalty needs the pod watch, which starts only when it is set at startup")
+	}
+
+	se.config.Store(next)
+	se.queryLimiter.SetLimit(queryRateLimit(next))
+	se.precomputeCachedScores(next)
+
+	log.Printf("Reloaded configuration, version %s", next.version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"version": next.version})
+}
+
+// redactedValue replaces secrets in /config output.
+const redactedValue = "REDACTED"
+
+// configHandler returns the effective configuration after environment and
+// file merging, with secrets redacted. It reflects reloads immediately.
+func (se *SchedulerExtender) configHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := se.config.Load()
+	writeConditionalJSON(w, r, cfg, redactConfig(cfg), cfg.loadedAt)
+}
+
+// redactConfig returns a copy of cfg safe to show: the reload token, the
+// Prometheus token file path and Prometheus header values, which often
+// carry credentials, are replaced.
+func redactConfig(cfg *ExtenderConfig) *ExtenderConfig {
+	redacted := *cfg
+	if redacted.ReloadToken != "" {
+		redacted.ReloadToken = redactedValue
+	}
+	if redacted.PrometheusTokenFile != "" {
+		redacted.PrometheusTokenFile = redactedValue
+	}
+	if len(cfg.PrometheusHeaders) > 0 {
+		redacted.PrometheusHeaders = make(map[string]string, len(cfg.PrometheusHeaders))
+		for name := range cfg.PrometheusHeaders {
+			redacted.PrometheusHeaders[name] = redactedValue
+		}
+	}
+	return &redacted
+}
+
+// validToken checks a bearer token in constant time. An empty expected
+// token disables the endpoint.
+func validToken(r *http.Request, expected string) bool {
+	if expected == "" {
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(expected)) == 1
+}
+
+// envLookup returns the value of an environment variable, or "" when it
+// is unset.
+type envLookup func(key string) string
+
+func (env envLookup) str(key, defaultValue string) string {
+	if value := env(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func (env envLookup) int(key string, defaultValue int) int {
+	if value := env(key); value != "" {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
+func (env envLookup) float(key string, defaultValue float64) float64 {
+	if value := env(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// floatMap parses "key=value,key=value" into a map, skipping
+// malformed pairs.
+func (env envLookup) floatMap(key string) map[string]float64 {
+	result := make(map[string]float64)
+	for _, pair := range strings.Split(env(key), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		if floatValue, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			result[strings.TrimSpace(k)] = floatValue
+		} else {
+			log.Printf("Ignoring invalid %s entry %q", key, pair)
+		}
+	}
+	return result
+}
+
+// list parses a comma-separated list, dropping empty entries.
+func (env envLookup) list(key string) []string {
+	var result []string
+	for _, item := range strings.Split(env(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// stringMap parses "key=value,key=value" into a map, skipping
+// malformed pairs.
+func (env envLookup) stringMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(env(key), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return result
+}
+
+func (env envLookup) bool(key string, defaultValue bool) bool {
+	if value := env(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
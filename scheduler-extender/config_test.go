@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func reload(t *testing.T, se *SchedulerExtender, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/reload", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	se.reloadHandler(rec, req)
+	return rec
+}
+
+// Run under -race: requests keep scoring on the config they loaded while
+// reloads swap it and recompute the cached scores.
+func TestReloadUnderConcurrentPrioritize(t *testing.T) {
+	t.Setenv("RELOAD_TOKEN", "secret")
+	t.Setenv("DEBUG", "false")
+	t.Setenv("CACHE_TTL", "3600")
+	t.Setenv("POD_SCORE_CACHE", "true")
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.ReloadToken = "secret"
+		cfg.PodScoreCache = true
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "low-rtt", RTTp99: 10, CPUUtil: 90},
+		&NodeMetrics{NodeName: "low-cpu", RTTp99: 900, CPUUtil: 10},
+	)
+	body := extenderArgs(testPod("web", nil), "low-rtt", "low-cpu")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				rec := httptest.NewRecorder()
+				se.prioritize(rec, httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(body)))
+				if rec.Code != http.StatusOK {
+					t.Errorf("prioritize status %d: %s", rec.Code, rec.Body)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		rtt, cpu := "0.9", "0"
+		if i%2 == 0 {
+			rtt, cpu = "0", "0.9"
+		}
+		t.Setenv("WEIGHT_RTT_P99", rtt)
+		t.Setenv("WEIGHT_CPU_UTIL", cpu)
+		if rec := reload(t, se, "secret"); rec.Code != http.StatusOK {
+			t.Errorf("reload status %d: %s", rec.Code, rec.Body)
+			break
+		}
+		// Let the requests run between reloads even on one CPU
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+
+	// The last reload weighted RTT, and every cached score must follow it
+	scores := scoresByHost(callPrioritize(t, se, body))
+	if scores["low-rtt"] <= scores["low-cpu"] {
+		t.Errorf("ranking does not follow the reloaded weights: %v", scores)
+	}
+}
+
+func TestReloadRequiresToken(t *testing.T) {
+	t.Setenv("RELOAD_TOKEN", "secret")
+	se := newTestExtender(t, testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.ReloadToken = "secret"
+	}), nil)
+	before := se.config.Load()
+
+	if rec := reload(t, se, "wrong"); rec.Code != http.StatusForbidden {
+		t.Errorf("reload with a bad token: status %d", rec.Code)
+	}
+	if se.config.Load() != before {
+		t.Error("config replaced by an unauthorized reload")
+	}
+}
+
+func TestConfigEndpointReflectsReloadAndRedacts(t *testing.T) {
+	t.Setenv("RELOAD_TOKEN", "secret")
+	t.Setenv("PROMETHEUS_TOKEN_FILE", "/var/run/secrets/prometheus/token")
+	t.Setenv("PROMETHEUS_HEADERS", "X-Scope-OrgID=tenant-a")
+	se := newTestExtender(t, testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.ReloadToken = "secret"
+	}), nil)
+
+	getConfig := func() map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		se.configHandler(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding /config: %v: %s", err, rec.Body)
+		}
+		return body
+	}
+
+	t.Setenv("WEIGHT_RTT_P99", "0.7")
+	if rec := reload(t, se, "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("reload status %d: %s", rec.Code, rec.Body)
+	}
+	body := getConfig()
+	if got := body["weights"].(map[string]interface{})["rtt_p99"]; got != 0.7 {
+		t.Errorf("rtt_p99 weight %v after reload, want 0.7", got)
+	}
+	for _, field := range []string{"reload_token", "prometheus_token_file"} {
+		if body[field] != redactedValue {
+			t.Errorf("%s = %v, want it redacted", field, body[field])
+		}
+	}
+	if got := body["prometheus_headers"].(map[string]interface{})["X-Scope-OrgID"]; got != redactedValue {
+		t.Errorf("header value %v, want it redacted", got)
+	}
+
+	// Redaction works on a copy
+	if cfg := se.config.Load(); cfg.ReloadToken != "secret" || cfg.PrometheusTokenFile == redactedValue {
+		t.Error("redaction modified the live config")
+	}
+}
+
+func TestNeutralScoreForUnknownNode(t *testing.T) {
+	for _, neutral := range []float64{0, 50, 100} {
+		cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.NeutralScore = neutral })
+		se := newTestExtender(t, cfg, nil)
+		se.seedCache(cfg, &NodeMetrics{NodeName: "known", RTTp99: 5})
+
+		scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), "known", "unknown")))
+		if scores["unknown"] != int64(neutral) {
+			t.Errorf("neutral %v: unknown node scored %d", neutral, scores["unknown"])
+		}
+	}
+
+	for _, neutral := range []float64{-1, 101} {
+		cfg := defaultConfig()
+		cfg.NeutralScore = neutral
+		if validateConfig(cfg) == nil {
+			t.Errorf("neutral score %v accepted", neutral)
+		}
+	}
+}
+
+func TestDisabledMetricSkipsQueryAndScore(t *testing.T) {
+	series := promSeries{
+		"ebpf_rtt_p99_milliseconds": {"a": 20, "b": 20},
+		"ebpf_drop_rate":            {"a": 0, "b": 800},
+	}
+	refresh := func(disabled []string) (map[string]float64, []string) {
+		t.Helper()
+		var mu sync.Mutex
+		var queries []string
+		prom := fakePrometheus(t, series)
+		recorder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			mu.Lock()
+			queries = append(queries, r.Form.Get("query"))
+			mu.Unlock()
+			prom.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer recorder.Close()
+
+		cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.DisabledMetrics = disabled })
+		se := newTestExtender(t, cfg, nil)
+		client, _ := api.NewClient(api.Config{Address: recorder.URL})
+		se.promClient = v1.NewAPI(client)
+		if err := se.updateMetrics(context.Background(), cfg); err != nil {
+			t.Fatalf("updateMetrics: %v", err)
+		}
+		return map[string]float64{
+			"a": se.calculateNodeScore(cfg, "a", scoreOptions{}),
+			"b": se.calculateNodeScore(cfg, "b", scoreOptions{}),
+		}, queries
+	}
+
+	scores, queries := refresh(nil)
+	if scores["b"] >= scores["a"] {
+		t.Fatalf("lossy node scored %v, clean node %v", scores["b"], scores["a"])
+	}
+	if !queried(queries, "ebpf_drop_rate") {
+		t.Fatal("drop_rate not queried while enabled")
+	}
+
+	scores, queries = refresh([]string{"drop_rate"})
+	if math.Abs(scores["a"]-scores["b"]) > 1e-9 {
+		t.Errorf("drop_rate disabled: scores still differ: %v", scores)
+	}
+	if queried(queries, "ebpf_drop_rate") {
+		t.Error("drop_rate queried while disabled")
+	}
+	if !queried(queries, "ebpf_rtt_p99_milliseconds") {
+		t.Error("disabling drop_rate stopped other queries")
+	}
+}
+
+func queried(queries []string, metric string) bool {
+	for _, query := range queries {
+		if strings.Contains(query, metric) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDefaultConfigIgnoresEnvironment(t *testing.T) {
+	t.Setenv("CACHE_TTL", "1")
+	t.Setenv("WEIGHT_RTT_P99", "0.9")
+
+	cfg := defaultConfig()
+	if cfg.CacheTTL != 10 || cfg.Weights.RTTp99 != 0.3 {
+		t.Errorf("defaultConfig read the environment: cache TTL %d, RTT weight %.2f", cfg.CacheTTL, cfg.Weights.RTTp99)
+	}
+
+	loaded, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if loaded.CacheTTL != 1 || loaded.Weights.RTTp99 != 0.9 {
+		t.Errorf("loadConfig ignored the environment: cache TTL %d, RTT weight %.2f", loaded.CacheTTL, loaded.Weights.RTTp99)
+	}
+}
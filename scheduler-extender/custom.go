@@ -0,0 +1,77 @@
+package main
+
+import "fmt"
+
+// CustomMetric is an operator-defined metric, typically backed by an eBPF
+// recording rule, scored alongside the built-in ones. Its weight applies
+// under the base weights and every profile.
+type CustomMetric struct {
+	Name          string  `json:"name"`
+	Query         string  `json:"query"`
+	Weight        float64 `json:"weight"`
+	Min           float64 `json:"min"`
+	Max           float64 `json:"max"`
+	LowerIsBetter bool    `json:"lower_is_better"`
+	// Aggregation combines duplicate series for one node; avg if unset
+	Aggregation string `json:"aggregation,omitempty"`
+}
+
+// spec describes the metric for querying and normalization. Custom specs
+// have no weight or value accessors; see metricSpec.set.
+func (c *CustomMetric) spec() metricSpec {
+	aggregation := c.Aggregation
+	if aggregation == "" {
+		aggregation = aggAvg
+	}
+	return metricSpec{
+		name:          c.Name,
+		query:         c.Query,
+		min:           c.Min,
+		max:           c.Max,
+		lowerIsBetter: c.LowerIsBetter,
+		aggregation:   aggregation,
+	}
+}
+
+func validateCustomMetrics(metrics []CustomMetric, allowNegative bool) error {
+	seen := make(map[string]bool)
+	for _, c := range metrics {
+		switch {
+		case c.Name == "":
+			return fmt.Errorf("custom metric without a name")
+		case lookupMetricSpec(c.Name) != nil:
+			return fmt.Errorf("custom metric %s clashes with a built-in metric", c.Name)
+		case seen[c.Name]:
+			return fmt.Errorf("duplicate custom metric %s", c.Name)
+		case c.Query == "":
+			return fmt.Errorf("custom metric %s has no query", c.Name)
+		case c.Weight < 0 && !allowNegative:
+			return fmt.Errorf("custom metric %s has negative weight %.2f", c.Name, c.Weight)
+		case c.Max <= c.Min:
+			return fmt.Errorf("custom metric %s: max %.2f must be above min %.2f", c.Name, c.Max, c.Min)
+		case c.Aggregation != "" && !validAggregation(c.Aggregation):
+			return fmt.Errorf("custom metric %s has invalid aggregation %q", c.Name, c.Aggregation)
+		}
+		seen[c.Name] = true
+	}
+	return nil
+}
+
+// knownMetric reports whether name is a built-in or custom metric.
+func (cfg *ExtenderConfig) knownMetric(name string) bool {
+	_, ok := cfg.metricSpec(name)
+	return ok
+}
+
+// metricSpec returns the built-in or custom metric named name.
+func (cfg *ExtenderConfig) metricSpec(name string) (metricSpec, bool) {
+	if spec := lookupMetricSpec(name); spec != nil {
+		return *spec, true
+	}
+	for i := range cfg.CustomMetrics {
+		if cfg.CustomMetrics[i].Name == name {
+			return cfg.CustomMetrics[i].spec(), true
+		}
+	}
+	return metricSpec{}, false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCustomMetricEndToEnd(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.CustomMetrics = []CustomMetric{{
+			Name:          "xdp_pressure",
+			Query:         "ebpf_xdp_pressure_ratio",
+			Weight:        0.5,
+			Min:           0,
+			Max:           100,
+			LowerIsBetter: true,
+		}}
+	})
+	se := newTestExtender(t, cfg, promSeries{
+		"ebpf_xdp_pressure_ratio":   {"calm": 10, "pressured": 90},
+		"ebpf_rtt_p99_milliseconds": {"calm": 50, "pressured": 50},
+	})
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+
+	se.mu.RLock()
+	for node, want := range map[string]float64{"calm": 10, "pressured": 90} {
+		if got := se.metricsCache[node].Custom["xdp_pressure"]; got != want {
+			t.Errorf("%s xdp_pressure %v, want %v", node, got, want)
+		}
+	}
+	se.mu.RUnlock()
+
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), "calm", "pressured")))
+	if scores["calm"] <= scores["pressured"] {
+		t.Errorf("calm node scored %d, pressured %d", scores["calm"], scores["pressured"])
+	}
+}
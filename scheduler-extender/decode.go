@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// How fields in a request body that our vendored types don't know about
+// are handled. They usually mean kube-scheduler speaks a newer protocol.
+const (
+	unknownFieldsIgnore = "ignore"
+	unknownFieldsLog    = "log"
+	unknownFieldsReject = "reject"
+)
+
+// unknownFieldPolicy returns the configured policy, logging by default
+// only in debug mode.
+func (se *SchedulerExtender) unknownFieldPolicy(cfg *ExtenderConfig) string {
+	if cfg.UnknownFields != "" {
+		return cfg.UnknownFields
+	}
+	if cfg.Debug {
+		return unknownFieldsLog
+	}
+	return unknownFieldsIgnore
+}
+
+// decodeRequest decodes a JSON request body into v. Unknown fields never
+// break decoding unless the policy is reject; with the log policy a strict
+// second pass reports them to catch protocol drift early.
+func (se *SchedulerExtender) decodeRequest(cfg *ExtenderConfig, r *http.Request, v interface{}) error {
+	policy := se.unknownFieldPolicy(cfg)
+	if policy == unknownFieldsIgnore {
+		return json.NewDecoder(r.Body).Decode(v)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	strict := json.NewDecoder(bytes.NewReader(body))
+	strict.DisallowUnknownFields()
+	strictErr := strict.Decode(v)
+	if strictErr == nil {
+		return nil
+	}
+	if !strings.Contains(strictErr.Error(), "unknown field") {
+		return strictErr
+	}
+
+	if policy == unknownFieldsReject {
+		return fmt.Errorf("request contains unsupported fields: %w", strictErr)
+	}
+
+	log.Printf("Request to %s contains fields unknown to this extender (%v); kube-scheduler may be newer", r.URL.Path, strictErr)
+	return json.Unmarshal(body, v)
+}
+
+func validUnknownFieldPolicy(policy string) bool {
+	switch policy {
+	case "", unknownFieldsIgnore, unknownFieldsLog, unknownFieldsReject:
+		return true
+	}
+	return false
+}
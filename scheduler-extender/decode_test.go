@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLog collects the standard logger's output for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+	return &buf
+}
+
+func TestUnknownFieldsLoggedInDebug(t *testing.T) {
+	// A newer kube-scheduler adds a field our vendored ExtenderArgs lacks
+	body := `{"pod":{"metadata":{"name":"web","namespace":"default"}},"nodenames":["a","b"],"schedulingHints":{"x":1}}`
+
+	tests := []struct {
+		name   string
+		mutate func(*ExtenderConfig)
+		logged bool
+		status int
+	}{
+		{"debug", func(cfg *ExtenderConfig) { cfg.Debug = true }, true, http.StatusOK},
+		{"default", nil, false, http.StatusOK},
+		{"log", func(cfg *ExtenderConfig) { cfg.UnknownFields = unknownFieldsLog }, true, http.StatusOK},
+		{"reject", func(cfg *ExtenderConfig) { cfg.UnknownFields = unknownFieldsReject }, false, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, tt.mutate)
+			se := newTestExtender(t, cfg, nil)
+			se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5}, &NodeMetrics{NodeName: "b", RTTp99: 500})
+			logs := captureLog(t)
+
+			rec := httptest.NewRecorder()
+			se.prioritize(rec, httptest.NewRequest(http.MethodPost, "/prioritize", strings.NewReader(body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if logged := strings.Contains(logs.String(), "schedulingHints"); logged != tt.logged {
+				t.Errorf("unknown field logged = %v, want %v: %q", logged, tt.logged, logs)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), `"Host":"b"`) {
+				t.Errorf("request not decoded: %s", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// maxDedupeEntries bounds the dedupe cache; it is reset when full.
+const maxDedupeEntries = 1024
+
+type dedupeEntry struct {
+	result  extenderv1.HostPriorityList
+	expires time.Time
+}
+
+// dedupeCache holds recent prioritize responses so a retried request is
+// answered without scoring it again.
+type dedupeCache struct {
+	mu      sync.Mutex
+	entries map[string]dedupeEntry
+}
+
+func newDedupeCache() *dedupeCache {
+	return &dedupeCache{entries: make(map[string]dedupeEntry)}
+}
+
+func (c *dedupeCache) get(key string, now time.Time) (extenderv1.HostPriorityList, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		return nil, false
+	}
+	return entry.result, true
+}
+
+func (c *dedupeCache) put(key string, result extenderv1.HostPriorityList, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxDedupeEntries {
+		c.entries = make(map[string]dedupeEntry)
+	}
+	c.entries[key] = dedupeEntry{result: result, expires: expires}
+}
+
+// dedupeGet returns the response to an identical prioritize request made
+// within DedupeWindow against the same cache generation.
+func (se *SchedulerExtender) dedupeGet(cfg *ExtenderConfig, args *extenderv1.ExtenderArgs, nodeNames []string, generation uint64) (extenderv1.HostPriorityList, bool) {
+	if cfg.DedupeWindow <= 0 {
+		return nil, false
+	}
+	return se.dedupe.get(dedupeKey(args, nodeNames, cfg.version, generation), se.clock.Now())
+}
+
+func (se *SchedulerExtender) dedupePut(cfg *ExtenderConfig, args *extenderv1.ExtenderArgs, nodeNames []string, generation uint64, result extenderv1.HostPriorityList) {
+	if cfg.DedupeWindow <= 0 {
+		return
+	}
+	expires := se.clock.Now().Add(time.Duration(cfg.DedupeWindow) * time.Second)
+	se.dedupe.put(dedupeKey(args, nodeNames, cfg.version, generation), result, expires)
+}
+
+// dedupeKey identifies a prioritize request by its pod, down to the pod's
+// resource version, its candidate nodes, the config version and the
+// metrics cache generation it was scored against.
+func dedupeKey(args *extenderv1.ExtenderArgs, nodeNames []string, configVersion string, generation uint64) string {
+	h := sha256.New()
+	if pod := args.Pod; pod != nil {
+		h.Write([]byte(strings.Join([]string{pod.Namespace, pod.Name, string(pod.UID), pod.ResourceVersion}, "\x00")))
+	}
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(nodeNames, "\x00")))
+	h.Write([]byte{0})
+	h.Write([]byte(configVersion))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatUint(generation, 10)))
+	return hex.EncodeToString(h.Sum(nil))
+}
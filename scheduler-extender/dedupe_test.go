@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRepeatPrioritizeServedFromDedupe(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.DedupeWindow = 5 })
+	se := newTestExtender(t, cfg, nil)
+	clock := newFakeClock()
+	se.clock = clock
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 20}, &NodeMetrics{NodeName: "b", RTTp99: 400})
+	body := extenderArgs(testPod("web", nil), "a", "b")
+
+	first := callPrioritize(t, se, body)
+	second := callPrioritize(t, se, body)
+	if n := se.scoreComputations.Load(); n != 1 {
+		t.Errorf("%d rankings computed for a repeated request, want 1", n)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("repeat answered %v, first request %v", second, first)
+	}
+
+	// Another pod on the same nodes is scored afresh
+	callPrioritize(t, se, extenderArgs(testPod("api", nil), "a", "b"))
+	if n := se.scoreComputations.Load(); n != 2 {
+		t.Errorf("%d rankings computed, want a new one for a different pod", n)
+	}
+
+	// Past the window the repeat is scored again
+	clock.advance(5 * time.Second)
+	callPrioritize(t, se, body)
+	if n := se.scoreComputations.Load(); n != 3 {
+		t.Errorf("%d rankings computed, want a recompute once the dedupe window passed", n)
+	}
+}
+
+// A retry that arrives after the cache was refreshed must be ranked on
+// the new metrics, even inside the dedupe window.
+func TestDedupeMissesAfterRefresh(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.DedupeWindow = 60
+		cfg.CacheTTL = 10
+	})
+	// Prometheus has a reversed view of the two nodes
+	se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 400, "b": 20}})
+	clock := newFakeClock()
+	se.clock = clock
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 20}, &NodeMetrics{NodeName: "b", RTTp99: 400})
+	body := extenderArgs(testPod("web", nil), "a", "b")
+
+	before := scoresByHost(callPrioritize(t, se, body))
+	if before["a"] <= before["b"] {
+		t.Fatalf("seeded cache should favor a: %v", before)
+	}
+
+	clock.advance(11 * time.Second)
+	after := scoresByHost(callPrioritize(t, se, body))
+	if n := se.scoreComputations.Load(); n != 2 {
+		t.Errorf("%d rankings computed, want a recompute after the refresh", n)
+	}
+	if after["b"] <= after["a"] {
+		t.Errorf("retry after a refresh served the old ranking: %v", after)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// drainHandler switches drained mode on or off. While drained the extender
+// stops influencing scheduling, e.g. during monitoring maintenance, without
+// a redeploy.
+func (se *SchedulerExtender) drainHandler(drain bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validToken(r, se.config.Load().ReloadToken) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		if se.drained.Swap(drain) != drain {
+			if drain {
+				log.Printf("Drained: passing all nodes and scoring them neutral")
+			} else {
+				log.Printf("Undrained: scoring on metrics again")
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"drained": drain})
+	}
+}
+
+// neutralRanking scores every node NeutralScore.
+func neutralRanking(cfg *ExtenderConfig, nodeNames []string) extenderv1.HostPriorityList {
+	ranking := make(extenderv1.HostPriorityList, 0, len(nodeNames))
+	for _, nodeName := range nodeNames {
+		ranking = append(ranking, extenderv1.HostPriority{Host: nodeName, Score: int64(cfg.NeutralScore)})
+	}
+	return ranking
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func postWithToken(t *testing.T, handler http.HandlerFunc, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func healthBody(se *SchedulerExtender) string {
+	rec := httptest.NewRecorder()
+	se.healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	return rec.Body.String()
+}
+
+func TestDrainedModeScoresNeutral(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.ReloadToken = "secret" })
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "good", RTTp99: 5},
+		&NodeMetrics{NodeName: "bad", RTTp99: 900, DropRate: 500},
+	)
+	body := extenderArgs(testPod("web", nil), "good", "bad", "unknown")
+
+	if rec := postWithToken(t, se.drainHandler(true), "/drain", "wrong"); rec.Code != http.StatusForbidden {
+		t.Fatalf("drain with a bad token: status %d", rec.Code)
+	}
+	if rec := postWithToken(t, se.drainHandler(true), "/drain", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("drain status %d: %s", rec.Code, rec.Body)
+	}
+	if got := healthBody(se); got != "OK (drained)" {
+		t.Errorf("health while drained = %q", got)
+	}
+	for host, score := range scoresByHost(callPrioritize(t, se, body)) {
+		if score != int64(cfg.NeutralScore) {
+			t.Errorf("drained: %s scored %d, want neutral %v", host, score, cfg.NeutralScore)
+		}
+	}
+
+	if rec := postWithToken(t, se.drainHandler(false), "/undrain", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("undrain status %d: %s", rec.Code, rec.Body)
+	}
+	if got := healthBody(se); got != "OK" {
+		t.Errorf("health after undrain = %q", got)
+	}
+	scores := scoresByHost(callPrioritize(t, se, body))
+	if scores["good"] <= scores["bad"] {
+		t.Errorf("undrained scores don't follow metrics: %v", scores)
+	}
+}
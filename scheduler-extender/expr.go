@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// filterExpr is a compiled boolean expression over node metrics, such as
+// "drop_rate > 5 AND rtt_p99 > 200". Metrics can be named as in the
+// metric specs or by their NodeMetrics field (DropRate, RTTp99).
+//
+//	expr    = and { ("OR" | "||") and }
+//	and     = unary { ("AND" | "&&") unary }
+//	unary   = ("NOT" | "!") unary | "(" expr ")" | operand cmp operand
+//	operand = metric | number
+//	cmp     = "<" | "<=" | ">" | ">=" | "==" | "!="
+type filterExpr struct {
+	source string
+	root   exprNode
+	// metrics are the metric names the expression reads
+	metrics map[string]bool
+}
+
+type exprNode interface {
+	eval(m *NodeMetrics) bool
+}
+
+type (
+	orNode  struct{ left, right exprNode }
+	andNode struct{ left, right exprNode }
+	notNode struct{ operand exprNode }
+	cmpNode struct {
+		op          string
+		left, right exprOperand
+	}
+)
+
+// exprOperand is either a metric or a constant.
+type exprOperand struct {
+	spec  *metricSpec
+	value float64
+}
+
+func (n orNode) eval(m *NodeMetrics) bool  { return n.left.eval(m) || n.right.eval(m) }
+func (n andNode) eval(m *NodeMetrics) bool { return n.left.eval(m) && n.right.eval(m) }
+func (n notNode) eval(m *NodeMetrics) bool { return !n.operand.eval(m) }
+
+func (n cmpNode) eval(m *NodeMetrics) bool {
+	l, r := n.left.get(m), n.right.get(m)
+	switch n.op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	}
+	return false
+}
+
+func (o exprOperand) get(m *NodeMetrics) float64 {
+	if o.spec != nil {
+		return *o.spec.value(m)
+	}
+	return o.value
+}
+
+// matches reports whether the expression holds for m.
+func (e *filterExpr) matches(m *NodeMetrics) bool {
+	return e.root.eval(m)
+}
+
+// compileFilterExpr parses source into a filterExpr.
+func compileFilterExpr(source string) (*filterExpr, error) {
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, metrics: make(map[string]bool)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return &filterExpr{source: source, root: root, metrics: p.metrics}, nil
+}
+
+// tokenizeExpr splits an expression into identifiers, numbers, operators
+// and parentheses.
+func tokenizeExpr(source string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case strings.ContainsRune("<>=!&|", c):
+			j := i + 1
+			if j < len(source) && strings.ContainsRune("=&|", rune(source[j])) {
+				j++
+			}
+			tokens = append(tokens, source[i:j])
+			i = j
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.' || c == '-':
+			j := i + 1
+			for j < len(source) {
+				d := rune(source[j])
+				if !unicode.IsLetter(d) && !unicode.IsDigit(d) && d != '_' && d != '.' {
+					break
+				}
+				j++
+			}
+			tokens = append(tokens, source[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens  []string
+	pos     int
+	metrics map[string]bool
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); strings.EqualFold(t, "OR") || t == "||"; t = p.peek() {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); strings.EqualFold(t, "AND") || t == "&&"; t = p.peek() {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	switch t := p.peek(); {
+	case strings.EqualFold(t, "NOT") || t == "!":
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	case t == "(":
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return inner, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("expected comparison after %q, got %q", p.tokens[p.pos-2], op)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return cmpNode{op: op, left: left, right: right}, nil
+}
+
+func (p *exprParser) parseOperand() (exprOperand, error) {
+	t := p.next()
+	if t == "" {
+		return exprOperand{}, fmt.Errorf("unexpected end of expression")
+	}
+	if v, err := strconv.ParseFloat(t, 64); err == nil {
+		return exprOperand{value: v}, nil
+	}
+	spec := lookupMetricByIdent(t)
+	if spec == nil {
+		return exprOperand{}, fmt.Errorf("unknown metric %q", t)
+	}
+	p.metrics[spec.name] = true
+	return exprOperand{spec: spec}, nil
+}
+
+// lookupMetricByIdent finds a metric by spec name or NodeMetrics field
+// name, ignoring case and underscores.
+func lookupMetricByIdent(ident string) *metricSpec {
+	fold := func(s string) string { return strings.ToLower(strings.ReplaceAll(s, "_", "")) }
+	want := fold(ident)
+	for i := range metricSpecs {
+		if fold(metricSpecs[i].name) == want {
+			return &metricSpecs[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestFilterExpressionNeedsBothDimensions(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.FilterExpression = "DropRate > 5 AND RTTp99 > 200" })
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "healthy", RTTp99: 20, DropRate: 1},
+		&NodeMetrics{NodeName: "lossy", RTTp99: 20, DropRate: 50},
+		&NodeMetrics{NodeName: "slow", RTTp99: 500, DropRate: 1},
+		&NodeMetrics{NodeName: "lossy-slow", RTTp99: 500, DropRate: 50},
+	)
+
+	result := callFilter(t, se, extenderArgs(testPod("web", nil), "healthy", "lossy", "slow", "lossy-slow"))
+	if _, failed := result.FailedNodes["lossy-slow"]; !failed {
+		t.Error("node bad on both dimensions passed the filter")
+	}
+	for _, name := range []string{"healthy", "lossy", "slow"} {
+		if _, failed := result.FailedNodes[name]; failed {
+			t.Errorf("%s failed the filter: %s", name, result.FailedNodes[name])
+		}
+	}
+}
+
+func TestCompileFilterExpr(t *testing.T) {
+	m := &NodeMetrics{RTTp99: 250, DropRate: 3, CPUUtil: 90}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"drop_rate > 5 AND rtt_p99 > 200", false},
+		{"drop_rate > 5 OR rtt_p99 > 200", true},
+		{"DropRate <= 3 && RTTp99 >= 250", true},
+		{"NOT (cpu_util < 80)", true},
+		{"!(cpu_util < 80) && (drop_rate == 3 || drop_rate != 3)", true},
+		{"200 < rtt_p99 AND rtt_p99 < 300", true},
+		{"drop_rate > 5 OR rtt_p99 > 200 AND cpu_util < 50", false},
+	}
+	for _, tt := range tests {
+		expr, err := compileFilterExpr(tt.expr)
+		if err != nil {
+			t.Errorf("compiling %q: %v", tt.expr, err)
+			continue
+		}
+		if got := expr.root.eval(m); got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "drop_rate >", "bogus > 1", "drop_rate > 5 AND", "(rtt_p99 > 1", "rtt_p99 ~ 1"} {
+		if _, err := compileFilterExpr(bad); err == nil {
+			t.Errorf("%q compiled", bad)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	v1core "k8s.io/api/core/v1"
+)
+
+// capacityReference is the largest allocatable CPU (cores) and memory
+// (bytes) among the candidate nodes.
+type capacityReference struct {
+	cpu, memory float64
+}
+
+// largestAllocatable finds the capacity reference for nodes.
+func largestAllocatable(nodes map[string]*v1core.Node) capacityReference {
+	var ref capacityReference
+	for _, node := range nodes {
+		cpu, memory := nodeAllocatable(node)
+		if cpu > ref.cpu {
+			ref.cpu = cpu
+		}
+		if memory > ref.memory {
+			ref.memory = memory
+		}
+	}
+	return ref
+}
+
+func nodeAllocatable(node *v1core.Node) (cpu, memory float64) {
+	if q, ok := node.Status.Allocatable[v1core.ResourceCPU]; ok {
+		cpu = q.AsApproximateFloat64()
+	}
+	if q, ok := node.Status.Allocatable[v1core.ResourceMemory]; ok {
+		memory = q.AsApproximateFloat64()
+	}
+	return cpu, memory
+}
+
+// allocatableScore scores a node without metrics by its allocatable CPU
+// and memory relative to the largest candidate, so bigger nodes are
+// preferred when there is nothing better to go on. ok is false when the
+// node object or its allocatable is unknown.
+func allocatableScore(node *v1core.Node, ref capacityReference) (score float64, ok bool) {
+	if node == nil || ref.cpu <= 0 || ref.memory <= 0 {
+		return 0, false
+	}
+	cpu, memory := nodeAllocatable(node)
+	if cpu <= 0 && memory <= 0 {
+		return 0, false
+	}
+	return minScore + (maxScore-minScore)*(cpu/ref.cpu+memory/ref.memory)/2, true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestGzipPrioritize(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5}, &NodeMetrics{NodeName: "b", RTTp99: 800})
+	want := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), "a", "b")))
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write(extenderArgs(testPod("web", nil), "a", "b"))
+	gz.Close()
+
+	for _, accept := range []string{"gzip", "gzip;q=0", ""} {
+		req := httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(body.Bytes()))
+		req.Header.Set("Content-Encoding", "gzip")
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+		rec := httptest.NewRecorder()
+		withGzip(se.prioritize)(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Accept-Encoding %q: status %d: %s", accept, rec.Code, rec.Body)
+		}
+
+		var payload io.Reader = rec.Body
+		gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != (accept == "gzip") {
+			t.Errorf("Accept-Encoding %q: response gzipped = %v", accept, gzipped)
+		}
+		if gzipped {
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("response is not gzip: %v", err)
+			}
+			payload = zr
+		}
+		var result extenderv1.HostPriorityList
+		if err := json.NewDecoder(payload).Decode(&result); err != nil {
+			t.Fatalf("Accept-Encoding %q: decoding response: %v", accept, err)
+		}
+		if got := scoresByHost(result); got["a"] != want["a"] || got["b"] != want["b"] {
+			t.Errorf("Accept-Encoding %q: scores %v, want %v", accept, got, want)
+		}
+	}
+
+	// A body claiming gzip that isn't is a client error
+	req := httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(extenderArgs(testPod("web", nil), "a")))
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	withGzip(se.prioritize)(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("corrupt gzip body: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// hedgedResult carries one attempt's outcome back to hedged.
+type hedgedResult struct {
+	value model.Value
+	err   error
+}
+
+// hedged runs query and, if it hasn't answered within delay, starts a
+// second identical attempt when allowHedge permits. The first attempt to
+// succeed wins and the other is cancelled. A failed attempt only wins once
+// no other attempt is left running.
+func hedged(ctx context.Context, delay time.Duration, allowHedge func() bool, query func(context.Context) (model.Value, error)) (model.Value, error) {
+	if delay <= 0 {
+		return query(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgedResult, 2)
+	attempt := func() {
+		value, err := query(ctx)
+		results <- hedgedResult{value: value, err: err}
+	}
+
+	go attempt()
+	running := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-timer.C:
+			if running > 0 && allowHedge() {
+				go attempt()
+				running++
+			}
+		case r := <-results:
+			running--
+			if r.err == nil {
+				return r.value, nil
+			}
+			lastErr = r.err
+			if running == 0 {
+				return nil, lastErr
+			}
+		}
+	}
+}
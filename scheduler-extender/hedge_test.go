@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func TestHedgedQueryUsesFasterAnswer(t *testing.T) {
+	var attempts atomic.Int32
+	cancelled := make(chan struct{})
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(r.Form.Get("query"), "ebpf_rtt_p99_milliseconds") {
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+			return
+		}
+		value := "10"
+		if attempts.Add(1) == 1 {
+			// The first attempt stalls until the hedge wins and cancels it
+			select {
+			case <-r.Context().Done():
+				close(cancelled)
+				return
+			case <-time.After(5 * time.Second):
+			}
+			value = "999"
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"node":"a"},"value":[1760000000,%q]}]}}`, value)
+	}))
+	defer prom.Close()
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.HedgeDelay = 50 })
+	se := newTestExtender(t, cfg, nil)
+	client, _ := api.NewClient(api.Config{Address: prom.URL})
+	se.promClient = v1.NewAPI(client)
+
+	start := time.Now()
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("refresh took %v, waiting on the slow attempt", elapsed)
+	}
+	if got := se.metricsCache["a"].RTTp99; got != 10 {
+		t.Errorf("RTT = %v, want the hedge's answer 10", got)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("%d attempts, want the original and one hedge", n)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("slow attempt was not cancelled")
+	}
+}
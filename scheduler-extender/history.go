@@ -0,0 +1,187 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type historyEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Score     float64   `json:"score"`
+}
+
+// scoreRing is a fixed-size ring buffer of a node's recent scores.
+type scoreRing struct {
+	entries []historyEntry
+	next    int
+	full    bool
+}
+
+func (r *scoreRing) add(e historyEntry) {
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the entries oldest first.
+func (r *scoreRing) list() []historyEntry {
+	if !r.full {
+		return append([]historyEntry(nil), r.entries[:r.next]...)
+	}
+	out := make([]historyEntry, 0, len(r.entries))
+	out = append(out, r.entries[r.next:]...)
+	return append(out, r.entries[:r.next]...)
+}
+
+// scoreHistory keeps a bounded score history per node.
+type scoreHistory struct {
+	mu         sync.Mutex
+	maxEntries int
+	nodes      map[string]*scoreRing
+}
+
+func newScoreHistory(maxEntries int) *scoreHistory {
+	return &scoreHistory{maxEntries: maxEntries, nodes: make(map[string]*scoreRing)}
+}
+
+func (h *scoreHistory) record(nodeName string, e historyEntry) {
+	if h.maxEntries <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ring, ok := h.nodes[nodeName]
+	if !ok {
+		ring = &scoreRing{entries: make([]historyEntry, h.maxEntries)}
+		h.nodes[nodeName] = ring
+	}
+	ring.add(e)
+}
+
+// prune drops the history of nodes missing from cache, such as nodes
+// removed from the cluster.
+func (h *scoreHistory) prune(cache map[string]*NodeMetrics) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for nodeName := range h.nodes {
+		if _, ok := cache[nodeName]; !ok {
+			delete(h.nodes, nodeName)
+		}
+	}
+}
+
+func (h *scoreHistory) get(nodeName string) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ring, ok := h.nodes[nodeName]
+	if !ok {
+		return []historyEntry{}
+	}
+	return ring.list()
+}
+
+// averages returns each node's mean score over its history.
+func (h *scoreHistory) averages() map[string]float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	averages := make(map[string]float64, len(h.nodes))
+	for nodeName, ring := range h.nodes {
+		entries := ring.list()
+		if len(entries) == 0 {
+			continue
+		}
+		sum := 0.0
+		for _, e := range entries {
+			sum += e.Score
+		}
+		averages[nodeName] = sum / float64(len(entries))
+	}
+	return averages
+}
+
+type worstNode struct {
+	Node         string  `json:"node"`
+	AverageScore float64 `json:"average_score"`
+	// WorstMetric is the metric costing the node the most score in its
+	// latest breakdown
+	WorstMetric string `json:"worst_metric,omitempty"`
+}
+
+// worstHandler lists the ?n= (default 5) nodes with the lowest average
+// score over the history window, as candidates to investigate or cordon.
+func (se *SchedulerExtender) worstHandler(w http.ResponseWriter, r *http.Request) {
+	n := 5
+	if v := r.URL.Query().Get("n"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("invalid n %q", v), http.StatusBadRequest)
+			return
+		}
+		n = parsed
+	}
+
+	worst := make([]worstNode, 0)
+	for nodeName, average := range se.history.averages() {
+		worst = append(worst, worstNode{Node: nodeName, AverageScore: average})
+	}
+	slices.SortFunc(worst, func(a, b worstNode) int {
+		if c := cmp.Compare(a.AverageScore, b.AverageScore); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Node, b.Node)
+	})
+	worst = worst[:min(n, len(worst))]
+
+	se.mu.RLock()
+	for i := range worst {
+		if metrics, ok := se.metricsCache[worst[i].Node]; ok {
+			worst[i].WorstMetric = costliestMetric(metrics.Breakdown)
+		}
+	}
+	se.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(worst)
+}
+
+// costliestMetric returns the metric whose shortfall from best, scaled by
+// its weight, is largest.
+func costliestMetric(breakdown map[string]ScoreComponent) string {
+	worst, worstLoss := "", 0.0
+	for name, c := range breakdown {
+		loss := c.Weight * (1 - c.Normalized)
+		if loss > worstLoss || (loss == worstLoss && loss > 0 && name < worst) {
+			worst, worstLoss = name, loss
+		}
+	}
+	return worst
+}
+
+// historyHandler returns a node's recent scores, oldest first.
+func (se *SchedulerExtender) historyHandler(w http.ResponseWriter, r *http.Request) {
+	nodeName := r.URL.Query().Get("node")
+	if nodeName == "" {
+		http.Error(w, "missing node parameter", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Node    string         `json:"node"`
+		Entries []historyEntry `json:"entries"`
+	}{Node: nodeName, Entries: se.history.get(nodeName)})
+}
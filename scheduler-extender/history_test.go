@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWorstHandlerOrdering(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "good", RTTp99: 5},
+		&NodeMetrics{NodeName: "lossy", RTTp99: 5, DropRate: 900},
+		&NodeMetrics{NodeName: "slow", RTTp99: 950},
+	)
+	// Score once for the breakdowns, then replace the history
+	for _, nodeName := range []string{"good", "lossy", "slow"} {
+		se.calculateNodeScore(cfg, nodeName, scoreOptions{})
+	}
+	se.history = newScoreHistory(cfg.HistoryMaxEntries)
+	now := se.clock.Now()
+	for nodeName, scores := range map[string][]float64{
+		"good":  {90, 94, 92},
+		"lossy": {40, 60},
+		"slow":  {20, 30, 40},
+	} {
+		for _, score := range scores {
+			se.history.record(nodeName, historyEntry{Timestamp: now, Score: score})
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	se.worstHandler(rec, httptest.NewRequest(http.MethodGet, "/worst?n=2", nil))
+	var worst []worstNode
+	if err := json.Unmarshal(rec.Body.Bytes(), &worst); err != nil {
+		t.Fatalf("decoding /worst: %v: %s", err, rec.Body)
+	}
+	want := []worstNode{
+		{Node: "slow", AverageScore: 30, WorstMetric: "rtt_p99"},
+		{Node: "lossy", AverageScore: 50, WorstMetric: "drop_rate"},
+	}
+	if len(worst) != len(want) {
+		t.Fatalf("got %d nodes, want %d: %+v", len(worst), len(want), worst)
+	}
+	for i := range want {
+		if worst[i] != want[i] {
+			t.Errorf("worst[%d] = %+v, want %+v", i, worst[i], want[i])
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	se.worstHandler(rec, httptest.NewRequest(http.MethodGet, "/worst?n=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("n=0: status %d, want 400", rec.Code)
+	}
+}
+
+func TestHistoryRecordsMetricScore(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "canary", RTTp99: 5, CPUUtil: 5})
+
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "canary",
+		Annotations: map[string]string{canaryAnnotation: "true"},
+	}}
+	score := se.calculateNodeScore(cfg, "canary", scoreOptions{nodes: map[string]*v1core.Node{"canary": node}})
+	if score != cfg.CanaryScoreCap {
+		t.Fatalf("canary scored %v, want the cap %v", score, cfg.CanaryScoreCap)
+	}
+
+	history := se.history.get("canary")
+	if len(history) != 1 {
+		t.Fatalf("history has %d entries, want 1", len(history))
+	}
+	if history[0].Score <= cfg.CanaryScoreCap {
+		t.Errorf("history recorded %v, the capped score rather than the metric score", history[0].Score)
+	}
+}
+
+func TestHistoryPrunedWithCache(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": {"kept": 10}})
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "kept", RTTp99: 10},
+		&NodeMetrics{NodeName: "removed", RTTp99: 10},
+	)
+	se.calculateNodeScore(cfg, "kept", scoreOptions{})
+	se.calculateNodeScore(cfg, "removed", scoreOptions{})
+
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+	if got := se.history.get("removed"); len(got) != 0 {
+		t.Errorf("history kept %d entries for a node gone from the cache", len(got))
+	}
+	if got := se.history.get("kept"); len(got) != 1 {
+		t.Errorf("history has %d entries for a cached node, want 1", len(got))
+	}
+}
+
+func TestHistoryHandlerBoundedAndOrdered(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.HistoryMaxEntries = 5 })
+	se := newTestExtender(t, cfg, nil)
+	clock := newFakeClock()
+	se.clock = clock
+
+	var scores []float64
+	for i := 0; i < 8; i++ {
+		se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: float64(100 * i)})
+		scores = append(scores, se.calculateNodeScore(cfg, "a", scoreOptions{}))
+		clock.advance(time.Minute)
+	}
+
+	rec := httptest.NewRecorder()
+	se.historyHandler(rec, httptest.NewRequest(http.MethodGet, "/history?node=a", nil))
+	var resp struct {
+		Node    string         `json:"node"`
+		Entries []historyEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding /history: %v: %s", err, rec.Body)
+	}
+	if len(resp.Entries) != 5 {
+		t.Fatalf("history has %d entries, want the 5 most recent", len(resp.Entries))
+	}
+	// Oldest first, keeping only the last five scores
+	for i, entry := range resp.Entries {
+		if want := scores[3+i]; math.Abs(entry.Score-want) > 1e-9 {
+			t.Errorf("entry %d scored %v, want %v", i, entry.Score, want)
+		}
+		if i > 0 && !entry.Timestamp.After(resp.Entries[i-1].Timestamp) {
+			t.Errorf("entry %d at %v is not after entry %d at %v", i, entry.Timestamp, i-1, resp.Entries[i-1].Timestamp)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	se.historyHandler(rec, httptest.NewRequest(http.MethodGet, "/history", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("missing node: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConditionalGet(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5})
+
+	get := func(handler http.HandlerFunc, path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	for path, handler := range map[string]http.HandlerFunc{"/metrics": se.metricsHandler, "/config": se.configHandler} {
+		first := get(handler, path, "")
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || etag == "" || first.Header().Get("Cache-Control") == "" {
+			t.Fatalf("%s: status %d, ETag %q, Cache-Control %q", path, first.Code, etag, first.Header().Get("Cache-Control"))
+		}
+		if rec := get(handler, path, etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s with a matching ETag: status %d, %d body bytes; want an empty 304", path, rec.Code, rec.Body.Len())
+		}
+		if rec := get(handler, path, `W/`+etag); rec.Code != http.StatusNotModified {
+			t.Errorf("%s with a weak ETag: status %d, want 304", path, rec.Code)
+		}
+		if rec := get(handler, path, `"stale"`); rec.Code != http.StatusOK {
+			t.Errorf("%s with a stale ETag: status %d, want 200", path, rec.Code)
+		}
+	}
+
+	// New data gets a new ETag
+	etag := get(se.metricsHandler, "/metrics", "").Header().Get("ETag")
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 50})
+	if rec := get(se.metricsHandler, "/metrics", etag); rec.Code != http.StatusOK {
+		t.Errorf("/metrics after a refresh: status %d, want 200", rec.Code)
+	}
+}
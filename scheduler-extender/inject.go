@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// injectHandler merges the posted node metrics into the cache, bypassing
+// Prometheus, so e2e tests can drive a real kube-scheduler through chosen
+// metrics. It takes the same formats as -simulate: a list of NodeMetrics
+// or a map keyed by node name. Entries without a timestamp count as
+// fetched now. The next successful refresh replaces them.
+func (se *SchedulerExtender) injectHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := se.config.Load()
+	if !cfg.InjectEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validToken(r, cfg.ReloadToken) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	injected, err := parseMetricsDump(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode metrics: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	now := se.clock.Now()
+	for nodeName, metrics := range injected {
+		metrics.NodeName = nodeName
+		if metrics.Timestamp == 0 {
+			metrics.Timestamp = now.Unix()
+			metrics.fetchedAt = now
+		}
+	}
+
+	se.mu.Lock()
+	cache := make(map[string]*NodeMetrics, len(se.metricsCache)+len(injected))
+	for nodeName, metrics := range se.metricsCache {
+		cache[nodeName] = metrics
+	}
+	for nodeName, metrics := range injected {
+		cache[nodeName] = metrics
+	}
+	precomputeScores(cfg, injected)
+	se.metricsCache = cache
+	se.lastUpdate = now
+	se.generation++
+	se.mu.Unlock()
+	se.ready.Store(true)
+
+	log.Printf("Injected metrics for %d nodes", len(injected))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"injected": len(injected)})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInjectedMetricsUsedByPrioritize(t *testing.T) {
+	post := func(se *SchedulerExtender, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/inject", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		se.injectHandler(rec, req)
+		return rec
+	}
+	const metrics = `{"fast": {"rtt_p99_ms": 10}, "slow": {"rtt_p99_ms": 800, "drop_rate": 400}}`
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.ReloadToken = "secret" })
+	se := newTestExtender(t, cfg, nil)
+	if rec := post(se, "secret", metrics); rec.Code != http.StatusNotFound {
+		t.Errorf("inject while disabled: status %d, want 404", rec.Code)
+	}
+
+	cfg = testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.ReloadToken = "secret"
+		cfg.InjectEnabled = true
+	})
+	se = newTestExtender(t, cfg, nil)
+	if rec := post(se, "wrong", metrics); rec.Code != http.StatusForbidden {
+		t.Errorf("inject with a bad token: status %d, want 403", rec.Code)
+	}
+	if rec := post(se, "secret", metrics); rec.Code != http.StatusOK {
+		t.Fatalf("inject: status %d: %s", rec.Code, rec.Body)
+	}
+
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), "fast", "slow")))
+	if scores["fast"] <= scores["slow"] {
+		t.Errorf("after inject: fast node scored %d, slow node %d", scores["fast"], scores["slow"])
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"slices"
+
+	v1core "k8s.io/api/core/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// jitterTies spreads nodes sharing a score by adding up to ScoreJitter
+// points to each. The jitter stays below the gap to the next higher score,
+// so it only orders tied nodes and never overtakes a better one. It is
+// derived from the pod and node names, so it is stable within a scheduling
+// cycle but differs between pods. The ranking is copied, leaving cached
+// rankings untouched.
+func jitterTies(cfg *ExtenderConfig, pod *v1core.Pod, ranking extenderv1.HostPriorityList) extenderv1.HostPriorityList {
+	if cfg.ScoreJitter <= 0 || pod == nil {
+		return ranking
+	}
+
+	tied := make(map[int64]int)
+	for _, hp := range ranking {
+		tied[hp.Score]++
+	}
+
+	// headroom is how far each tied score can rise without reaching the
+	// next score up
+	scores := make([]int64, 0, len(tied))
+	for score := range tied {
+		scores = append(scores, score)
+	}
+	slices.Sort(scores)
+	headroom := make(map[int64]float64, len(scores))
+	for i, score := range scores {
+		next := int64(maxScore) + 1
+		if i+1 < len(scores) {
+			next = scores[i+1]
+		}
+		headroom[score] = math.Min(cfg.ScoreJitter, float64(next-score-1))
+	}
+
+	seed := string(pod.UID)
+	if seed == "" {
+		seed = pod.Namespace + "/" + pod.Name
+	}
+	jittered := make(extenderv1.HostPriorityList, len(ranking))
+	for i, hp := range ranking {
+		if tied[hp.Score] > 1 && headroom[hp.Score] > 0 {
+			h := fnv.New64a()
+			h.Write([]byte(seed))
+			h.Write([]byte{0})
+			h.Write([]byte(hp.Host))
+			u := float64(mix64(h.Sum64())) / math.MaxUint64
+			hp.Score += int64(math.Round(u * headroom[hp.Score]))
+		}
+		jittered[i] = hp
+	}
+	return jittered
+}
+
+// mix64 spreads every input bit across the output. FNV alone barely moves
+// the high bits for names differing only in their last character, which
+// would give such nodes the same jitter.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestJitterSeparatesIdenticalNodes(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.ScoreJitter = 5 })
+	se := newTestExtender(t, cfg, nil)
+	names := []string{"a", "b", "c", "d", "e"}
+	var entries []*NodeMetrics
+	for _, name := range names {
+		entries = append(entries, &NodeMetrics{NodeName: name, RTTp99: 400, CPUUtil: 50})
+	}
+	se.seedCache(cfg, entries...)
+
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), names...)))
+	distinct := make(map[int64]bool)
+	lowest, highest := int64(maxScore), int64(minScore)
+	for _, score := range scores {
+		distinct[score] = true
+		lowest, highest = min(lowest, score), max(highest, score)
+	}
+	if len(distinct) < 2 {
+		t.Errorf("identical nodes still tied with jitter enabled: %v", scores)
+	}
+	if highest-lowest > int64(cfg.ScoreJitter) {
+		t.Errorf("scores spread by %d, more than the jitter of %v", highest-lowest, cfg.ScoreJitter)
+	}
+
+	// Jitter is stable for a pod
+	again := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), names...)))
+	for name, score := range scores {
+		if again[name] != score {
+			t.Errorf("node %s scored %d, then %d for the same pod", name, score, again[name])
+		}
+	}
+}
+
+func TestJitterNeverOvertakesBetterNode(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, jitter := range []float64{1, 3, 10, 100} {
+		cfg := &ExtenderConfig{ScoreJitter: jitter}
+		for round := 0; round < 200; round++ {
+			ranking := make(extenderv1.HostPriorityList, 2+rng.Intn(8))
+			for i := range ranking {
+				ranking[i] = extenderv1.HostPriority{
+					Host:  fmt.Sprintf("node-%d", i),
+					Score: int64(90 + rng.Intn(11)),
+				}
+			}
+			jittered := jitterTies(cfg, testPod(fmt.Sprintf("pod-%d", round), nil), ranking)
+
+			for i := range ranking {
+				if jittered[i].Score > int64(maxScore) {
+					t.Fatalf("jitter %v: %s scored %d", jitter, jittered[i].Host, jittered[i].Score)
+				}
+				for j := range ranking {
+					if ranking[i].Score < ranking[j].Score && jittered[i].Score >= jittered[j].Score {
+						t.Fatalf("jitter %v: %s (%d) caught up with %s (%d): %d vs %d", jitter,
+							ranking[i].Host, ranking[i].Score, ranking[j].Host, ranking[j].Score,
+							jittered[i].Score, jittered[j].Score)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestFractionalJitterRejected(t *testing.T) {
+	for _, jitter := range []float64{0.2, 0.49, 0.99} {
+		cfg := defaultConfig()
+		cfg.ScoreJitter = jitter
+		if validateConfig(cfg) == nil {
+			t.Errorf("jitter %v accepted, but rounds to no jitter at all", jitter)
+		}
+	}
+	for _, jitter := range []float64{0, 1, 2.5} {
+		cfg := defaultConfig()
+		cfg.ScoreJitter = jitter
+		if err := validateConfig(cfg); err != nil {
+			t.Errorf("jitter %v rejected: %v", jitter, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// unschedulableTaint is set by the node lifecycle controller on cordoned nodes.
+const unschedulableTaint = "node.kubernetes.io/unschedulable"
+
+// controlPlaneKeys mark control-plane nodes, as a label or taint key.
+// Older clusters still use the master role.
+var controlPlaneKeys = []string{
+	"node-role.kubernetes.io/control-plane",
+	"node-role.kubernetes.io/master",
+}
+
+// newKubeClient connects to the API server of the cluster we run in.
+func newKubeClient() (kubernetes.Interface, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return client, nil
+}
+
+// newNodeLister watches Node objects so that node state is available even
+// when the scheduler only sends NodeNames. The informer runs for the life
+// of the process; only the initial sync is bounded by ctx.
+func newNodeLister(ctx context.Context, client kubernetes.Interface) (listersv1.NodeLister, error) {
+	factory := informers.NewSharedInformerFactory(client, 0)
+	nodeInformer := factory.Core().V1().Nodes()
+	lister := nodeInformer.Lister()
+	stop := make(chan struct{})
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(ctx.Done(), nodeInformer.Informer().HasSynced) {
+		close(stop)
+		return nil, fmt.Errorf("node informer did not sync")
+	}
+	return lister, nil
+}
+
+// newPodLister watches pods for sibling counting, like newNodeLister.
+func newPodLister(ctx context.Context, client kubernetes.Interface) (listersv1.PodLister, error) {
+	factory := informers.NewSharedInformerFactory(client, 0)
+	podInformer := factory.Core().V1().Pods()
+	lister := podInformer.Lister()
+	stop := make(chan struct{})
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(ctx.Done(), podInformer.Informer().HasSynced) {
+		close(stop)
+		return nil, fmt.Errorf("pod informer did not sync")
+	}
+	return lister, nil
+}
+
+// nodesByName indexes the Node objects for the candidate nodes. Objects the
+// scheduler sent win; the rest come from the node lister, if any. Nodes the
+// lister failed to return are left out and reported in the error.
+func (se *SchedulerExtender) nodesByName(args *extenderv1.ExtenderArgs) (map[string]*v1core.Node, error) {
+	nodes := make(map[string]*v1core.Node)
+	if args.Nodes != nil {
+		for i := range args.Nodes.Items {
+			nodes[args.Nodes.Items[i].Name] = &args.Nodes.Items[i]
+		}
+	}
+	if se.nodeLister == nil {
+		return nodes, nil
+	}
+
+	var errs []error
+	for _, name := range candidateNodeNames(args) {
+		if _, ok := nodes[name]; ok {
+			continue
+		}
+		node, err := se.nodeLister.Get(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", name, err))
+			continue
+		}
+		nodes[name] = node
+	}
+	return nodes, errors.Join(errs...)
+}
+
+// controlPlaneNode reports whether node carries a control-plane role label
+// or taint.
+func controlPlaneNode(node *v1core.Node) bool {
+	if node == nil {
+		return false
+	}
+	for _, key := range controlPlaneKeys {
+		if _, ok := node.Labels[key]; ok {
+			return true
+		}
+		for _, taint := range node.Spec.Taints {
+			if taint.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toleratesControlPlane reports whether pod tolerates any control-plane
+// taint.
+func toleratesControlPlane(pod *v1core.Pod) bool {
+	if pod == nil {
+		return false
+	}
+	for _, key := range controlPlaneKeys {
+		taint := v1core.Taint{Key: key, Effect: v1core.TaintEffectNoSchedule}
+		for i := range pod.Spec.Tolerations {
+			if pod.Spec.Tolerations[i].ToleratesTaint(&taint) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nodeDisrupted reports whether a node is cordoned or being drained.
+func nodeDisrupted(node *v1core.Node) bool {
+	if node == nil {
+		return false
+	}
+	if node.Spec.Unschedulable {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == unschedulableTaint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// fakeNodeLister serves nodes as a synced informer would.
+func fakeNodeLister(t *testing.T, nodes ...*v1core.Node) listersv1.NodeLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		if err := indexer.Add(node); err != nil {
+			t.Fatalf("adding node %s: %v", node.Name, err)
+		}
+	}
+	return listersv1.NewNodeLister(indexer)
+}
+
+func TestCordonedNodesPenalizedAndFiltered(t *testing.T) {
+	cordoned := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "cordoned"}, Spec: v1core.NodeSpec{Unschedulable: true}}
+	tainted := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "tainted"}, Spec: v1core.NodeSpec{
+		Taints: []v1core.Taint{{Key: unschedulableTaint, Effect: v1core.TaintEffectNoSchedule}},
+	}}
+	healthy := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "healthy"}}
+	entries := func() []*NodeMetrics {
+		return []*NodeMetrics{
+			{NodeName: "cordoned", RTTp99: 5},
+			{NodeName: "tainted", RTTp99: 5},
+			{NodeName: "healthy", RTTp99: 5},
+		}
+	}
+	body := extenderArgs(testPod("web", nil), "cordoned", "tainted", "healthy")
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.DisruptionPenalty = 40 })
+	se := newTestExtender(t, cfg, nil)
+	se.nodeLister = fakeNodeLister(t, cordoned, tainted, healthy)
+	se.seedCache(cfg, entries()...)
+
+	scores := scoresByHost(callPrioritize(t, se, body))
+	for _, name := range []string{"cordoned", "tainted"} {
+		if scores[name] != scores["healthy"]-40 {
+			t.Errorf("%s node scored %d, want the healthy node's %d less the penalty", name, scores[name], scores["healthy"])
+		}
+	}
+
+	cfg = testConfig(t, func(cfg *ExtenderConfig) { cfg.FilterDisruptedNodes = true })
+	se = newTestExtender(t, cfg, nil)
+	se.nodeLister = fakeNodeLister(t, cordoned, tainted, healthy)
+	se.seedCache(cfg, entries()...)
+
+	result := callFilter(t, se, body)
+	for _, name := range []string{"cordoned", "tainted"} {
+		if _, failed := result.FailedNodes[name]; !failed {
+			t.Errorf("%s node passed the filter", name)
+		}
+	}
+	if _, failed := result.FailedNodes["healthy"]; failed {
+		t.Errorf("healthy node failed: %s", result.FailedNodes["healthy"])
+	}
+}
+
+func TestControlPlaneNodesFiltered(t *testing.T) {
+	labelled := &v1core.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "control-plane",
+		Labels: map[string]string{"node-role.kubernetes.io/control-plane": ""},
+	}}
+	master := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "master"}, Spec: v1core.NodeSpec{
+		Taints: []v1core.Taint{{Key: "node-role.kubernetes.io/master", Effect: v1core.TaintEffectNoSchedule}},
+	}}
+	worker := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker"}}
+	filter := func(scoreControlPlane bool, pod *v1core.Pod) extenderv1.ExtenderFilterResult {
+		cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.ScoreControlPlane = scoreControlPlane })
+		se := newTestExtender(t, cfg, nil)
+		se.nodeLister = fakeNodeLister(t, labelled, master, worker)
+		se.seedCache(cfg,
+			&NodeMetrics{NodeName: "control-plane", RTTp99: 5},
+			&NodeMetrics{NodeName: "master", RTTp99: 5},
+			&NodeMetrics{NodeName: "worker", RTTp99: 5},
+		)
+		return callFilter(t, se, extenderArgs(pod, "control-plane", "master", "worker"))
+	}
+
+	result := filter(false, testPod("web", nil))
+	for _, name := range []string{"control-plane", "master"} {
+		if _, failed := result.FailedNodes[name]; !failed {
+			t.Errorf("by default %s node passed the filter", name)
+		}
+	}
+	if _, failed := result.FailedNodes["worker"]; failed {
+		t.Errorf("worker node failed: %s", result.FailedNodes["worker"])
+	}
+
+	result = filter(true, testPod("web", nil))
+	if len(result.FailedNodes) != 0 {
+		t.Errorf("with ScoreControlPlane: failed nodes %v, want none", result.FailedNodes)
+	}
+
+	// Pods tolerating the taint may land on control-plane nodes
+	agent := testPod("agent", nil)
+	agent.Spec.Tolerations = []v1core.Toleration{{Key: "node-role.kubernetes.io/control-plane", Operator: v1core.TolerationOpExists}}
+	if result := filter(false, agent); result.FailedNodes["control-plane"] != "" {
+		t.Errorf("tolerating pod: control-plane node failed: %s", result.FailedNodes["control-plane"])
+	}
+}
+
+func TestAllocatableFallbackForUnmeteredNodes(t *testing.T) {
+	sized := func(name, cpu, memory string) *v1core.Node {
+		node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		node.Status.Allocatable = v1core.ResourceList{
+			v1core.ResourceCPU:    resource.MustParse(cpu),
+			v1core.ResourceMemory: resource.MustParse(memory),
+		}
+		return node
+	}
+	lister := fakeNodeLister(t, sized("big", "64", "256Gi"), sized("small", "4", "16Gi"), sized("metered", "8", "32Gi"))
+	body := extenderArgs(testPod("web", nil), "big", "small", "metered")
+
+	for _, fallback := range []bool{true, false} {
+		cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.AllocatableFallback = fallback })
+		se := newTestExtender(t, cfg, nil)
+		se.nodeLister = lister
+		se.seedCache(cfg, &NodeMetrics{NodeName: "metered", RTTp99: 5})
+
+		scores := scoresByHost(callPrioritize(t, se, body))
+		neutral := int64(cfg.NeutralScore)
+		if !fallback {
+			if scores["big"] != neutral || scores["small"] != neutral {
+				t.Errorf("fallback off: unmetered nodes scored %v, want neutral", scores)
+			}
+			continue
+		}
+		if scores["big"] <= neutral {
+			t.Errorf("large unmetered node scored %d, want above neutral %d", scores["big"], neutral)
+		}
+		if scores["small"] >= scores["big"] {
+			t.Errorf("small node scored %d, large node %d", scores["small"], scores["big"])
+		}
+	}
+}
+
+// unreachableNodeLister fails every lookup as an unreachable API server would.
+type unreachableNodeLister struct{}
+
+func (unreachableNodeLister) List(labels.Selector) ([]*v1core.Node, error) {
+	return nil, errors.New("dial tcp 10.0.0.1:443: connect: connection refused")
+}
+
+func (unreachableNodeLister) Get(string) (*v1core.Node, error) {
+	return nil, errors.New("dial tcp 10.0.0.1:443: connect: connection refused")
+}
+
+func TestFilterReportsNodeLookupError(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.FilterDisruptedNodes = true })
+	se := newTestExtender(t, cfg, nil)
+	se.nodeLister = unreachableNodeLister{}
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5}, &NodeMetrics{NodeName: "b", RTTp99: 5})
+
+	result := callFilter(t, se, extenderArgs(testPod("web", nil), "a", "b"))
+	if !strings.Contains(result.Error, "connection refused") {
+		t.Errorf("Error = %q, want the lookup failure", result.Error)
+	}
+	if len(result.FailedNodes) != 0 || result.NodeNames == nil || len(*result.NodeNames) != 2 {
+		t.Errorf("failed %v, passed %v; want every node passed", result.FailedNodes, result.NodeNames)
+	}
+}
+
+// fakePodLister serves pods as a synced informer would.
+func fakePodLister(t *testing.T, pods ...*v1core.Pod) listersv1.PodLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range pods {
+		if err := indexer.Add(pod); err != nil {
+			t.Fatalf("adding pod %s: %v", pod.Name, err)
+		}
+	}
+	return listersv1.NewPodLister(indexer)
+}
+
+func TestSpreadPenaltyFavoursEmptierNode(t *testing.T) {
+	var pods []*v1core.Pod
+	add := func(name, app, nodeName string, phase v1core.PodPhase) {
+		pod := testPod(name, nil)
+		pod.Labels = map[string]string{"app": app, "pod-template-hash": name}
+		pod.Spec.NodeName = nodeName
+		pod.Status.Phase = phase
+		pods = append(pods, pod)
+	}
+	for i, nodeName := range []string{"crowded", "crowded", "crowded", "some"} {
+		add(fmt.Sprintf("web-%d", i), "web", nodeName, v1core.PodRunning)
+	}
+	add("db-0", "db", "empty", v1core.PodRunning)
+	add("web-done", "web", "empty", v1core.PodSucceeded)
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.SpreadPenalty = 30 })
+	se := newTestExtender(t, cfg, nil)
+	se.podLister = fakePodLister(t, pods...)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "crowded", RTTp99: 300, CPUUtil: 40},
+		&NodeMetrics{NodeName: "some", RTTp99: 300, CPUUtil: 40},
+		&NodeMetrics{NodeName: "empty", RTTp99: 300, CPUUtil: 40},
+	)
+
+	pod := testPod("web-new", nil)
+	pod.Labels = map[string]string{"app": "web", "pod-template-hash": "new"}
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(pod, "crowded", "some", "empty")))
+	if !(scores["empty"] > scores["some"] && scores["some"] > scores["crowded"]) {
+		t.Errorf("scores %v, want empty > some > crowded", scores)
+	}
+	if scores["empty"]-scores["crowded"] != 30 {
+		t.Errorf("crowded node penalized %d, want the full spread penalty of 30", scores["empty"]-scores["crowded"])
+	}
+
+	// Pods of another workload don't count
+	other := testPod("cache-0", nil)
+	other.Labels = map[string]string{"app": "cache"}
+	scores = scoresByHost(callPrioritize(t, se, extenderArgs(other, "crowded", "some", "empty")))
+	if scores["crowded"] != scores["empty"] {
+		t.Errorf("unrelated pod penalized for web's siblings: %v", scores)
+	}
+}
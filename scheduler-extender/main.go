@@ -3,192 +3,1065 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 )
 
 type SchedulerExtender struct {
-	promClient   v1.API
-	config       *ExtenderConfig
+	promClient v1.API
+	// config is swapped atomically on reload; handlers Load it once per
+	// request so they never mix old and new settings
+	config       atomic.Pointer[ExtenderConfig]
 	metricsCache map[string]*NodeMetrics
 	lastUpdate   time.Time
-}
+	// generation increments every time metricsCache is replaced
+	generation uint64
+	// mu guards metricsCache, lastUpdate and generation
+	mu sync.RWMutex
+	// refreshMu is held while a refresh is in flight; lastRefresh is when
+	// the latest one started
+	refreshMu   sync.Mutex
+	lastRefresh time.Time
+	// ready flips once the first refresh from Prometheus succeeds or a
+	// cache file is restored
+	ready     atomic.Bool
+	clock     Clock
+	accessLog *log.Logger
+	// startedAt anchors WarmupPeriod; zero when scoring offline
+	startedAt time.Time
+	// inflight counts filter and prioritize calls being served
+	inflight atomic.Int64
+	// drained makes filter pass every node and prioritize score every node
+	// neutral, toggled through /drain and /undrain
+	drained atomic.Bool
+
+	// queryLimiter paces Prometheus queries
+	queryLimiter *rate.Limiter
+	// kubeClient talks to the API server; nil outside a cluster
+	kubeClient kubernetes.Interface
+	// nodeLister looks up Node objects the scheduler didn't send; nil
+	// outside a cluster
+	nodeLister listersv1.NodeLister
+	// podLister finds a pod's siblings; set only with SpreadPenalty
+	podLister listersv1.PodLister
 
-type ExtenderConfig struct {
-	PrometheusURL string       `json:"prometheus_url"`
-	Weights       ScoreWeights `json:"weights"`
-	Port          int          `json:"port"`
-	Debug         bool         `json:"debug"`
-	CacheTTL      int          `json:"cache_ttl_seconds"`
+	podScores   *podScoreCache
+	dedupe      *dedupeCache
+	history     *scoreHistory
+	promMetrics *extenderMetrics
+	// scoreComputations counts rankings computed rather than served from podScores
+	scoreComputations atomic.Uint64
 }
 
 type ScoreWeights struct {
-	RTTp99      float64 `json:"rtt_p99"`
-	RetransRate float64 `json:"retrans_rate"`
-	DropRate    float64 `json:"drop_rate"`
-	RunqlatP95  float64 `json:"runqlat_p95"`
-	CPUUtil     float64 `json:"cpu_util"`
+	RTTp99                  float64 `json:"rtt_p99"`
+	RetransRate             float64 `json:"retrans_rate"`
+	DropRate                float64 `json:"drop_rate"`
+	RunqlatP95              float64 `json:"runqlat_p95"`
+	CPUUtil                 float64 `json:"cpu_util"`
+	ZeroWindowRate          float64 `json:"zero_window_rate"`
+	PacketProcP99           float64 `json:"packet_proc_p99"`
+	MemPressure             float64 `json:"mem_pressure"`
+	FDUtil                  float64 `json:"fd_util"`
+	Bufferbloat             float64 `json:"bufferbloat"`
+	EBPFProgramCPU          float64 `json:"ebpf_program_cpu"`
+	ConnEstablishLatencyP95 float64 `json:"conn_establish_latency_p95"`
+	RTORate                 float64 `json:"rto_rate"`
+	SocketBufferPressure    float64 `json:"socket_buffer_pressure"`
+	RouteChangesRate        float64 `json:"route_changes_rate"`
+	MPTCPSubflowHealth      float64 `json:"mptcp_subflow_health"`
+	SidecarLatencyP95       float64 `json:"sidecar_latency_p95"`
+	CwndCollapseRate        float64 `json:"cwnd_collapse_rate"`
+	AQMScore                float64 `json:"aqm_score"`
+	RetransConnections      float64 `json:"retrans_connections"`
 }
 
 type NodeMetrics struct {
-	NodeName    string  `json:"node_name"`
-	RTTp99      float64 `json:"rtt_p99_ms"`
-	RetransRate float64 `json:"retrans_rate"`
-	DropRate    float64 `json:"drop_rate"`
-	RunqlatP95  float64 `json:"runqlat_p95_ms"`
-	CPUUtil     float64 `json:"cpu_util"`
-	Score       float64 `json:"score"`
-	Timestamp   int64   `json:"timestamp"`
+	NodeName                string  `json:"node_name"`
+	RTTp99                  float64 `json:"rtt_p99_ms"`
+	RetransRate             float64 `json:"retrans_rate"`
+	DropRate                float64 `json:"drop_rate"`
+	RunqlatP95              float64 `json:"runqlat_p95_ms"`
+	CPUUtil                 float64 `json:"cpu_util"`
+	ZeroWindowRate          float64 `json:"zero_window_rate"`
+	PacketProcP99           float64 `json:"packet_proc_p99_us"`
+	MemPressure             float64 `json:"mem_pressure"`
+	FDUtil                  float64 `json:"fd_util"`
+	Bufferbloat             float64 `json:"bufferbloat_ms"`
+	EBPFProgramCPU          float64 `json:"ebpf_program_cpu_cores"`
+	ConnEstablishLatencyP95 float64 `json:"conn_establish_latency_p95_ms"`
+	RTORate                 float64 `json:"rto_rate"`
+	SocketBufferPressure    float64 `json:"socket_buffer_pressure"`
+	RouteChangesRate        float64 `json:"route_changes_rate"`
+	MPTCPSubflowHealth      float64 `json:"mptcp_subflow_health"`
+	SidecarLatencyP95       float64 `json:"sidecar_latency_p95_ms"`
+	CwndCollapseRate        float64 `json:"cwnd_collapse_rate"`
+	AQMScore                float64 `json:"aqm_score"`
+	RetransConnections      float64 `json:"retrans_connections"`
+	Score                   float64 `json:"score"`
+	Timestamp               int64   `json:"timestamp"`
+	// SampleCounts is how many samples each metric with a sample count
+	// query is based on
+	SampleCounts map[string]float64 `json:"sample_counts,omitempty"`
+	// Observations counts the refreshes this node has been seen in
+	Observations int `json:"observations"`
+	// Stale is set when the entry was too old to be scored on
+	Stale bool `json:"stale,omitempty"`
+	// Missing lists queried metrics Prometheus had no value for
+	Missing []string `json:"missing,omitempty"`
+	// Custom holds the values of the configured CustomMetrics
+	Custom map[string]float64 `json:"custom,omitempty"`
+	// Trend is each metric's fitted change over the last TrendWindow
+	// refreshes, set with TrendPenalty
+	Trend map[string]float64 `json:"trend,omitempty"`
+	// Breakdown records how each weighted metric contributed to Score
+	Breakdown map[string]ScoreComponent `json:"breakdown,omitempty"`
+
+	precomputed *precomputedScore
+	// recent holds this and earlier refreshes' values for Trend
+	recent []trendPoint
+	// fetchedAt is when this process fetched the entry. Unlike Timestamp
+	// it keeps the clock's monotonic reading, so ages measured from it
+	// ignore wall-clock steps. Zero for entries restored from a file.
+	fetchedAt time.Time
+}
+
+type ScoreComponent struct {
+	Value        float64 `json:"value"`
+	Normalized   float64 `json:"normalized"`
+	Weight       float64 `json:"weight"`
+	Contribution float64 `json:"contribution"`
+	// Inverted is set for negatively weighted metrics, whose Normalized
+	// value is flipped so that doing well on them lowers the score
+	Inverted bool `json:"inverted,omitempty"`
+	// Confidence is below 1 for metrics backed by too few samples, whose
+	// Normalized value is pulled toward neutral to match
+	Confidence float64 `json:"confidence"`
+}
+
+// metricSpec ties a scored metric to its Prometheus query, normalization
+// bounds, weight and NodeMetrics field.
+type metricSpec struct {
+	name          string
+	query         string
+	min           float64
+	max           float64
+	lowerIsBetter bool
+	// aggregation combines duplicate series for one node unless overridden
+	aggregation string
+	weight      func(w *ScoreWeights) *float64
+	value       func(m *NodeMetrics) *float64
 }
 
+var metricSpecs = []metricSpec{
+	{
+		name: "rtt_p99", query: "ebpf_rtt_p99_milliseconds", min: 0, max: 1000, lowerIsBetter: true,
+		aggregation: aggMax,
+		weight:      func(w *ScoreWeights) *float64 { return &w.RTTp99 },
+		value:       func(m *NodeMetrics) *float64 { return &m.RTTp99 },
+	},
+	{
+		name: "retrans_rate", query: "ebpf_tcp_retrans_rate", min: 0, max: 100, lowerIsBetter: true,
+		aggregation: aggSum,
+		weight:      func(w *ScoreWeights) *float64 { return &w.RetransRate },
+		value:       func(m *NodeMetrics) *float64 { return &m.RetransRate },
+	},
+	{
+		name: "drop_rate", query: "ebpf_drop_rate", min: 0, max: 1000, lowerIsBetter: true,
+		aggregation: aggSum,
+		weight:      func(w *ScoreWeights) *float64 { return &w.DropRate },
+		value:       func(m *NodeMetrics) *float64 { return &m.DropRate },
+	},
+	{
+		name: "runqlat_p95", query: "ebpf_runqlat_p95_milliseconds", min: 0, max: 100, lowerIsBetter: true,
+		aggregation: aggMax,
+		weight:      func(w *ScoreWeights) *float64 { return &w.RunqlatP95 },
+		value:       func(m *NodeMetrics) *float64 { return &m.RunqlatP95 },
+	},
+	{
+		name: "cpu_util", query: "ebpf_cpu_utilization", min: 0, max: 100, lowerIsBetter: true,
+		aggregation: aggAvg,
+		weight:      func(w *ScoreWeights) *float64 { return &w.CPUUtil },
+		value:       func(m *NodeMetrics) *float64 { return &m.CPUUtil },
+	},
+	{
+		// Zero-window advertisements per second; frequent ones mean receiver
+		// buffers are exhausted and throughput is collapsing.
+		name: "zero_window_rate", query: "ebpf_tcp_zero_window_rate", min: 0, max: 100, lowerIsBetter: true,
+		aggregation: aggSum,
+		weight:      func(w *ScoreWeights) *float64 { return &w.ZeroWindowRate },
+		value:       func(m *NodeMetrics) *float64 { return &m.ZeroWindowRate },
+	},
+	{
+		// p99 per-packet processing time measured at XDP/tc; predicts latency spikes
+		name: "packet_proc_p99", query: "ebpf_packet_processing_p99_microseconds", min: 0, max: 1000, lowerIsBetter: true,
+		aggregation: aggMax,
+		weight:      func(w *ScoreWeights) *float64 { return &w.PacketProcP99 },
+		value:       func(m *NodeMetrics) *float64 { return &m.PacketProcP99 },
+	},
+	{
+		// Share of time tasks stalled on memory (PSI-style percentage)
+		name: "mem_pressure", query: "ebpf_mem_pressure", min: 0, max: 100, lowerIsBetter: true,
+		aggregation: aggAvg,
+		weight:      func(w *ScoreWeights) *float64 { return &w.MemPressure },
+		value:       func(m *NodeMetrics) *float64 { return &m.MemPressure },
+	},
+	{
+		// Open file descriptors as a percentage of the node's limit
+		name: "fd_util", query: "ebpf_fd_utilization", min: 0, max: 100, lowerIsBetter: true,
+		aggregation: aggAvg,
+		weight:      func(w *ScoreWeights) *float64 { return &w.FDUtil },
+		value:       func(m *NodeMetrics) *float64 { return &m.FDUtil },
+	},
+	{
+		// Queueing delay under load; catches bufferbloat that idle RTT p99 misses
+		name: "bufferbloat", query: "ebpf_queue_delay_under_load_milliseconds", min: 0, max: 500, lowerIsBetter: true,
+		aggregation: aggMax,
+		weight:      func(w *ScoreWeights) *float64 { return &w.Bufferbloat },
+		value:       func(m *NodeMetrics) *float64 { return &m.Bufferbloat },
+	},
+	{
+		// CPU cores consumed by loaded eBPF programs; the cost of observing the node
+		name: "ebpf_program_cpu", query: "rate(ebpf_program_cpu_seconds[1m])", min: 0, max: 1, lowerIsBetter: true,
+		aggregation: aggSum,
+		weight:      func(w *ScoreWeights) *float64 { return &w.EBPFProgramCPU },
+		value:       func(m *NodeMetrics) *float64 { return &m.EBPFProgramCPU },
+	},
+	{
+		// SYN to ACK handshake time; reflects both network and accept backlog health
+		name: "conn_establish_latency_p95", query: "ebpf_tcp_connect_latency_p95_milliseconds", min: 0, max: 1000, lowerIsBetter: true,
+		aggregation: aggMax,
+		weight:      func(w *ScoreWeights) *float64 { return &w.ConnEstablishLatencyP95 },
+		value:       func(m *NodeMetrics) *float64 { return &m.ConnEstablishLatencyP95 },
+	},
+	{
+		// Retransmission timeouts per second; unlike fast retransmits they stall the flow
+		name: "rto_rate", query: "ebpf_tcp_rto_rate", min: 0, max: 10, lowerIsBetter: true,
+		aggregation: aggSum,
+		weight:      func(w *ScoreWeights) *float64 { return &w.RTORate },
+		value:       func(m *NodeMetrics) *float64 { return &m.RTORate },
+	},
+	{
+		// Percent of socket buffer allocations hitting the limit; the node can't keep up with I/O
+		name: "socket_buffer_pressure", query: "ebpf_socket_buffer_pressure", min: 0, max: 100, lowerIsBetter: true,
+		aggregation: aggAvg,
+		weight:      func(w *ScoreWeights) *float64 { return &w.SocketBufferPressure },
+		value:       func(m *NodeMetrics) *float64 { return &m.SocketBufferPressure },
+	},
+	{
+		// Routing table changes per second; a flapping node has unstable connectivity
+		name: "route_changes_rate", query: "node_route_changes_rate", min: 0, max: 5, lowerIsBetter: true,
+		aggregation: aggMax,
+		weight:      func(w *ScoreWeights) *float64 { return &w.RouteChangesRate },
+		value:       func(m *NodeMetrics) *float64 { return &m.RouteChangesRate },
+	},
+	{
+		// Percent of MPTCP subflows that are established and not in backup; only matters to MPTCP workloads
+		name: "mptcp_subflow_health", query: "ebpf_mptcp_subflow_health", min: 0, max: 100, lowerIsBetter: false,
+		aggregation: aggAvg,
+		weight:      func(w *ScoreWeights) *float64 { return &w.MPTCPSubflowHealth },
+		value:       func(m *NodeMetrics) *float64 { return &m.MPTCPSubflowHealth },
+	},
+	{
+		// Latency the service mesh sidecar adds per request; only matters to meshed pods
+		name: "sidecar_latency_p95", query: "ebpf_sidecar_proxy_latency_p95_ms", min: 0, max: 20, lowerIsBetter: true,
+		aggregation: aggMax,
+		weight:      func(w *ScoreWeights) *float64 { return &w.SidecarLatencyP95 },
+		value:       func(m *NodeMetrics) *float64 { return &m.SidecarLatencyP95 },
+	},
+	{
+		// Congestion window collapses to the minimum per second; a lossy or congested path
+		name: "cwnd_collapse_rate", query: "ebpf_tcp_cwnd_collapse_rate", min: 0, max: 5, lowerIsBetter: true,
+		aggregation: aggSum,
+		weight:      func(w *ScoreWeights) *float64 { return &w.CwndCollapseRate },
+		value:       func(m *NodeMetrics) *float64 { return &m.CwndCollapseRate },
+	},
+	{
+		// Share of queue drops made early by AQM rather than at a full queue (tail drop)
+		name: "aqm_score", query: "ebpf_qdisc_aqm_drop_ratio", min: 0, max: 1, lowerIsBetter: false,
+		aggregation: aggAvg,
+		weight:      func(w *ScoreWeights) *float64 { return &w.AQMScore },
+		value:       func(m *NodeMetrics) *float64 { return &m.AQMScore },
+	},
+	{
+		// Distinct connections that retransmitted in the last interval; widespread loss rather than one bad flow
+		name: "retrans_connections", query: "ebpf_tcp_retrans_connections", min: 0, max: 100, lowerIsBetter: true,
+		aggregation: aggMax,
+		weight:      func(w *ScoreWeights) *float64 { return &w.RetransConnections },
+		value:       func(m *NodeMetrics) *float64 { return &m.RetransConnections },
+	},
+}
+
+// Scores handed back to the scheduler are kept within this range.
+const (
+	minScore            = 0.0
+	maxScore            = 100.0
+	defaultNeutralScore = 50.0
+)
+
+// smoothingPoints is how many samples a smoothing range query asks for.
+const smoothingPoints = 30
+
+// version is the binary version, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// canaryAnnotation marks a node under validation; set it to "true".
+const canaryAnnotation = "ebpf-scheduler/canary"
+
+// Node labels bounding a node's score, e.g. ebpf-scheduler/max-score=70.
+const (
+	minScoreLabel = "ebpf-scheduler/min-score"
+	maxScoreLabel = "ebpf-scheduler/max-score"
+)
+
+// nodeLabel is the label the eBPF agent uses to identify the node a sample belongs to.
+const nodeLabel = "node"
+
 func NewSchedulerExtender() (*SchedulerExtender, error) {
-	config := &ExtenderConfig{
-		PrometheusURL: getEnv("PROMETHEUS_URL", "http://prometheus.monitoring:9090"),
-		Port:          getEnvInt("PORT", 8080),
-		Debug:         getEnvBool("DEBUG", true),
-		CacheTTL:      getEnvInt("CACHE_TTL", 10),
-		Weights: ScoreWeights{
-			RTTp99:      0.3,
-			RetransRate: 0.2,
-			DropRate:    0.2,
-			RunqlatP95:  0.15,
-			CPUUtil:     0.15,
-		},
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
 	}
 
+	extender := &SchedulerExtender{
+		metricsCache: make(map[string]*NodeMetrics),
+		clock:        realClock{},
+		accessLog:    newAccessLogger(),
+		podScores:    newPodScoreCache(),
+		dedupe:       newDedupeCache(),
+		history:      newScoreHistory(config.HistoryMaxEntries),
+		queryLimiter: rate.NewLimiter(queryRateLimit(config), 1),
+		promMetrics:  newExtenderMetrics(),
+	}
+	extender.startedAt = extender.clock.Now()
+	extender.config.Store(config)
+
 	// Create Prometheus client
+	address, err := apiURL(config.PrometheusURL, config.Backend)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Prometheus URL: %w", err)
+	}
 	promConfig := api.Config{
-		Address: config.PrometheusURL,
+		Address: address,
+		RoundTripper: &headerRoundTripper{
+			next: &tokenFileRoundTripper{
+				next:  api.DefaultRoundTripper,
+				path:  func() string { return extender.config.Load().PrometheusTokenFile },
+				clock: extender.clock,
+			},
+			headers: func() map[string]string { return extender.config.Load().PrometheusHeaders },
+		},
 	}
 	promClient, err := api.NewClient(promConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Prometheus client: %w", err)
 	}
+	extender.promClient = v1.NewAPI(promClient)
 
-	extender := &SchedulerExtender{
-		promClient:   v1.NewAPI(promClient),
-		config:       config,
-		metricsCache: make(map[string]*NodeMetrics),
+	if client, err := newKubeClient(); err != nil {
+		log.Printf("Kubernetes API unavailable, relying on Node objects sent by the scheduler: %v", err)
+	} else {
+		extender.kubeClient = client
+
+		syncCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if lister, err := newNodeLister(syncCtx, client); err != nil {
+			log.Printf("Node lookups disabled, relying on Node objects sent by the scheduler: %v", err)
+		} else {
+			extender.nodeLister = lister
+		}
+		if config.SpreadPenalty > 0 {
+			if lister, err := newPodLister(syncCtx, client); err != nil {
+				log.Printf("Sibling spreading disabled: %v", err)
+			} else {
+				extender.podLister = lister
+			}
+		}
 	}
 
-	log.Printf("Scheduler Extender initialized with Prometheus URL: %s", config.PrometheusURL)
+	// Warm the cache from the previous run so early decisions aren't all neutral
+	if config.CacheFile != "" {
+		if err := extender.loadCacheFile(config); err != nil {
+			log.Printf("Failed to load cache file %s: %v", config.CacheFile, err)
+		}
+	}
+
+	log.Printf("Scheduler Extender initialized with %s URL: %s", config.Backend, address)
 	return extender, nil
 }
 
 func (se *SchedulerExtender) prioritize(w http.ResponseWriter, r *http.Request) {
-	if se.config.Debug {
+	cfg := se.config.Load()
+	if cfg.Debug {
 		log.Printf("Received prioritize request from %s", r.RemoteAddr)
 	}
 
+	start := time.Now()
 	var args extenderv1.ExtenderArgs
-	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+	if err := se.decodeRequest(cfg, r, &args); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to decode request: %v", err), http.StatusBadRequest)
 		return
 	}
+	if err := validateArgs(&args); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startRequestSpan(r, "prioritize")
+	defer span.End()
+
+	nodeNames := candidateNodeNames(&args)
+	span.SetAttributes(nodeCountAttr(len(nodeNames)))
+
+	scoreStart := time.Now()
+	var result extenderv1.HostPriorityList
+	if se.drained.Load() {
+		result = neutralRanking(cfg, nodeNames)
+	} else if remaining := se.warmupRemaining(cfg); remaining > 0 {
+		if cfg.Debug {
+			log.Printf("Warming up for another %s, scoring all nodes neutral", remaining.Round(time.Second))
+		}
+		result = neutralRanking(cfg, nodeNames)
+	} else {
+		se.refreshIfExpired(ctx)
+		if cfg.FailOnEmptyCache && se.cacheEmptyAndExpired(cfg) {
+			log.Printf("Metrics cache is empty and expired, failing prioritize")
+			http.Error(w, "no node metrics available", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Only look for a repeat once the cache is current, so a retry
+		// never outlives the metrics its answer was computed from
+		se.mu.RLock()
+		generation := se.generation
+		se.mu.RUnlock()
+		if cached, ok := se.dedupeGet(cfg, &args, nodeNames, generation); ok {
+			if cfg.Debug {
+				log.Printf("Repeat prioritize request, serving the previous ranking of %d nodes", len(cached))
+			}
+			result = cached
+		} else {
+			nodes, err := se.nodesByName(&args)
+			if err != nil {
+				log.Printf("Failed to look up nodes: %v", err)
+			}
+			result = jitterTies(cfg, args.Pod, se.rankNodes(cfg, args.Pod, nodeNames, nodes))
+			se.dedupePut(cfg, &args, nodeNames, generation, result)
+		}
+	}
+	recordNodeCount(w, len(result))
+	for _, hp := range result {
+		se.promMetrics.scores.Observe(float64(hp.Score))
+	}
+
+	encodeStart := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	end := time.Now()
+
+	durations := se.promMetrics.prioritizeDuration
+	durations.WithLabelValues(phaseDecode).Observe(scoreStart.Sub(start).Seconds())
+	durations.WithLabelValues(phaseScore).Observe(encodeStart.Sub(scoreStart).Seconds())
+	durations.WithLabelValues(phaseEncode).Observe(end.Sub(encodeStart).Seconds())
+	durations.WithLabelValues(phaseTotal).Observe(end.Sub(start).Seconds())
+
+	if cfg.Debug {
+		log.Printf("Returned scores for %d nodes in %v (decode %v, score %v, encode %v)", len(result),
+			end.Sub(start), scoreStart.Sub(start), encodeStart.Sub(scoreStart), end.Sub(encodeStart))
+	}
+}
+
+// rankNodes scores every candidate node for pod, reusing a cached ranking
+// for pods with the same profile and CPU request when PodScoreCache is
+// enabled.
+func (se *SchedulerExtender) rankNodes(cfg *ExtenderConfig, pod *v1core.Pod, nodeNames []string, nodes map[string]*v1core.Node) extenderv1.HostPriorityList {
+	opts := se.podScoreOptions(cfg, pod, nodeNames, nodes)
 
-	// Update metrics cache if needed
-	if time.Since(se.lastUpdate) > time.Duration(se.config.CacheTTL)*time.Second {
-		if err := se.updateMetrics(r.Context()); err != nil {
-			log.Printf("Failed to update metrics: %v", err)
-			// Continue with cached data
+	var key string
+	var generation uint64
+	if cfg.PodScoreCache {
+		se.mu.RLock()
+		generation = se.generation
+		se.mu.RUnlock()
+
+		key = podScoreKey(opts, nodeNames, adjustedNodes(nodeNames, opts), cfg.version)
+		if cached, ok := se.podScores.get(key, generation); ok {
+			if cfg.Debug {
+				log.Printf("Reusing cached ranking for %d nodes", len(cached))
+			}
+			return cached
 		}
 	}
 
+	se.scoreComputations.Add(1)
+
 	// Calculate scores for each node
-	var hostPriorities []extenderv1.HostPriority
-	
-	for _, node := range args.Nodes.Items {
-		nodeName := node.Name
-		score := se.calculateNodeScore(nodeName)
-		
+	hostPriorities := make(extenderv1.HostPriorityList, 0, len(nodeNames))
+	for _, nodeName := range nodeNames {
+		score := se.calculateNodeScore(cfg, nodeName, opts)
+
 		hostPriorities = append(hostPriorities, extenderv1.HostPriority{
 			Host:  nodeName,
 			Score: int64(score),
 		})
-		
-		if se.config.Debug {
+
+		if cfg.Debug {
 			log.Printf("Node %s scored: %d", nodeName, int64(score))
 		}
 	}
 
-	result := &extenderv1.HostPriorityList{
-		Items: hostPriorities,
+	if cfg.PodScoreCache {
+		se.podScores.put(key, generation, hostPriorities)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(result); err != nil {
-		log.Printf("Failed to encode response: %v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		return
-	}
+	return hostPriorities
+}
 
-	if se.config.Debug {
-		log.Printf("Returned scores for %d nodes", len(hostPriorities))
+// podScoreOptions gathers the per-pod inputs for scoring nodeNames.
+func (se *SchedulerExtender) podScoreOptions(cfg *ExtenderConfig, pod *v1core.Pod, nodeNames []string, nodes map[string]*v1core.Node) scoreOptions {
+	opts := scoreOptions{
+		cpuRequestCores: podCPURequestCores(pod),
+		nodes:           nodes,
+		profile:         podProfile(cfg, pod),
+		preferredZone:   podPreferredZone(pod),
+	}
+	if opts.profile == nil {
+		opts.profile = scheduledProfile(cfg, se.clock.Now())
 	}
+	if cfg.AllocatableFallback {
+		opts.capacity = largestAllocatable(nodes)
+	}
+	if cfg.SpreadPenalty > 0 {
+		opts.siblings = siblingCounts(se.podLister, pod)
+		for _, nodeName := range nodeNames {
+			opts.maxSiblings = max(opts.maxSiblings, opts.siblings[nodeName])
+		}
+	}
+	return opts
 }
 
 func (se *SchedulerExtender) filter(w http.ResponseWriter, r *http.Request) {
-	// For now, we don't filter nodes - just pass all through
+	cfg := se.config.Load()
 	var args extenderv1.ExtenderArgs
-	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+	if err := se.decodeRequest(cfg, r, &args); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to decode request: %v", err), http.StatusBadRequest)
 		return
 	}
+	if err := validateArgs(&args); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	result := &extenderv1.ExtenderFilterResult{
 		Nodes:       args.Nodes,
+		NodeNames:   args.NodeNames,
 		FailedNodes: make(extenderv1.FailedNodesMap),
 		Error:       "",
 	}
 
+	ctx, span := startRequestSpan(r, "filter")
+	defer span.End()
+
+	nodeNames := candidateNodeNames(&args)
+	recordNodeCount(w, len(nodeNames))
+	span.SetAttributes(nodeCountAttr(len(nodeNames)))
+
+	// A scheduler that can't ignore us treats our errors as fatal, which
+	// is what losing every metric should be: scoring is blind
+	if !cfg.Ignorable && !se.drained.Load() {
+		se.refreshIfExpired(ctx)
+		if se.metricsCoverage(nodeNames) == 0 {
+			log.Printf("No metrics for any of %d candidate nodes, failing filter", len(nodeNames))
+			result.Error = "no metrics for any candidate node; is Prometheus or the eBPF agent down?"
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+	}
+
+	metricFilters := len(cfg.FilterThresholds) > 0 || cfg.filterExpr != nil
+	if (metricFilters || nodeStateFilters(cfg)) && !se.drained.Load() {
+		if metricFilters {
+			se.refreshIfExpired(ctx)
+
+			// With too few nodes covered, filtering on metrics is
+			// arbitrary; leave the decision to the default scheduler
+			if coverage := se.metricsCoverage(nodeNames); coverage < cfg.MinFilterCoverage {
+				log.Printf("Warning: only %.0f%% of %d candidate nodes have metrics (minimum %.0f%%), not filtering on metrics",
+					coverage, len(nodeNames), cfg.MinFilterCoverage)
+				metricFilters = false
+			}
+		}
+
+		nodes, err := se.nodesByName(&args)
+		if err != nil {
+			// Keep every node schedulable; rejecting them all would block
+			// scheduling. Only an ignorable extender's error is safe to
+			// report, as it is logged rather than failing the pod.
+			log.Printf("Failed to look up nodes, passing all %d candidates: %v", len(nodeNames), err)
+			span.RecordError(err)
+			if cfg.Ignorable {
+				result.Error = fmt.Sprintf("node lookup failed, all nodes passed unfiltered: %v", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+		for _, nodeName := range nodeNames {
+			if reason := se.filterReason(cfg, args.Pod, nodeName, nodes[nodeName], metricFilters); reason != "" {
+				result.FailedNodes[nodeName] = reason
+				if cfg.Debug {
+					log.Printf("Node %s filtered: %s", nodeName, reason)
+				}
+			}
+		}
+
+		// Answer in the same shape the scheduler asked in
+		if args.NodeNames != nil {
+			passed := make([]string, 0, len(nodeNames))
+			for _, nodeName := range nodeNames {
+				if _, failed := result.FailedNodes[nodeName]; !failed {
+					passed = append(passed, nodeName)
+				}
+			}
+			result.NodeNames = &passed
+		} else if args.Nodes != nil {
+			passed := &v1core.NodeList{}
+			for _, node := range args.Nodes.Items {
+				if _, failed := result.FailedNodes[node.Name]; !failed {
+					passed.Items = append(passed.Items, node)
+				}
+			}
+			result.Nodes = passed
+		}
+		span.SetAttributes(attribute.Int("scheduler.failed_nodes", len(result.FailedNodes)))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
 
-func (se *SchedulerExtender) calculateNodeScore(nodeName string) float64 {
+// validateArgs rejects requests without candidate nodes, which point at a
+// scheduler misconfiguration rather than an empty cluster.
+func validateArgs(args *extenderv1.ExtenderArgs) error {
+	if len(candidateNodeNames(args)) == 0 {
+		return fmt.Errorf("request has no candidate nodes: both Nodes.Items and NodeNames are empty")
+	}
+	return nil
+}
+
+// candidateNodeNames returns the nodes being scheduled onto. With
+// nodeCacheCapable the scheduler sends only NodeNames instead of full
+// Node objects.
+func candidateNodeNames(args *extenderv1.ExtenderArgs) []string {
+	if args.NodeNames != nil {
+		return *args.NodeNames
+	}
+	if args.Nodes == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(args.Nodes.Items))
+	for _, node := range args.Nodes.Items {
+		names = append(names, node.Name)
+	}
+	return names
+}
+
+// nodeStateFilters reports whether filter checks Node objects.
+func nodeStateFilters(cfg *ExtenderConfig) bool {
+	return cfg.FilterDisruptedNodes || !cfg.ScoreControlPlane
+}
+
+// filterReason returns why a node should be filtered out for pod, or "" if
+// it passes every configured check. Metric checks only run when useMetrics
+// is set; nodes without metrics pass them.
+func (se *SchedulerExtender) filterReason(cfg *ExtenderConfig, pod *v1core.Pod, nodeName string, node *v1core.Node, useMetrics bool) string {
+	if cfg.FilterDisruptedNodes && nodeDisrupted(node) {
+		return "node is cordoned or draining"
+	}
+	// Pods tolerating the control-plane taint, such as node agents,
+	// asked to run there
+	if !cfg.ScoreControlPlane && controlPlaneNode(node) && !toleratesControlPlane(pod) {
+		return "node is a control-plane node"
+	}
+	if !useMetrics {
+		return ""
+	}
+
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+
 	metrics, exists := se.metricsCache[nodeName]
 	if !exists {
-		if se.config.Debug {
+		return ""
+	}
+
+	names := make([]string, 0, len(cfg.FilterThresholds))
+	for name := range cfg.FilterThresholds {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		spec, known := cfg.metricSpec(name)
+		if !known || cfg.disabledMetrics[name] {
+			continue
+		}
+		value, ok := spec.get(metrics)
+		if !ok {
+			continue
+		}
+		threshold := cfg.FilterThresholds[name]
+		if (spec.lowerIsBetter && value > threshold) || (!spec.lowerIsBetter && value < threshold) {
+			return fmt.Sprintf("%s %.2f exceeds threshold %.2f", name, value, threshold)
+		}
+	}
+
+	if cfg.filterExpr != nil && cfg.filterExpr.matches(metrics) {
+		return fmt.Sprintf("matches filter expression %q", cfg.filterExpr.source)
+	}
+
+	return ""
+}
+
+// metricsCoverage returns the percentage of nodeNames with cached metrics.
+func (se *SchedulerExtender) metricsCoverage(nodeNames []string) float64 {
+	if len(nodeNames) == 0 {
+		return 0
+	}
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+
+	covered := 0
+	for _, nodeName := range nodeNames {
+		if _, ok := se.metricsCache[nodeName]; ok {
+			covered++
+		}
+	}
+	return 100 * float64(covered) / float64(len(nodeNames))
+}
+
+// scoreOptions carries the per-request inputs to calculateNodeScore.
+type scoreOptions struct {
+	// cpuRequestCores is the pod's effective CPU request; heavier pods are
+	// pushed away from busy nodes harder
+	cpuRequestCores float64
+	// nodes holds the Node objects sent by the scheduler, if any
+	nodes map[string]*v1core.Node
+	// profile is the pod's weight profile, nil for the base weights
+	profile *WeightProfile
+	// capacity is the largest candidate's allocatable, set when
+	// AllocatableFallback is enabled
+	capacity capacityReference
+	// preferredZone is the zone the pod asks to run in, if any
+	preferredZone string
+	// siblings counts the pod's siblings per node, set with SpreadPenalty;
+	// maxSiblings is the highest count among the candidates
+	siblings    map[string]int
+	maxSiblings int
+}
+
+func (se *SchedulerExtender) calculateNodeScore(cfg *ExtenderConfig, nodeName string, opts scoreOptions) float64 {
+	score := se.computeNodeScore(cfg, nodeName, opts)
+
+	// History tracks the node's own metrics, not how it placed for a
+	// particular pod
+	se.history.record(nodeName, historyEntry{Timestamp: se.clock.Now(), Score: score})
+
+	return se.adjustNodeScore(cfg, nodeName, score, opts)
+}
+
+// adjustNodeScore applies the per-pod and per-node adjustments (zone,
+// spread, canary, label bounds, disruption) to a node's metric score.
+func (se *SchedulerExtender) adjustNodeScore(cfg *ExtenderConfig, nodeName string, score float64, opts scoreOptions) float64 {
+	// Same-zone nodes get a bonus on top of their metric score. Applied
+	// first so canary caps and label bounds still hold.
+	if nodeInZone(opts.nodes[nodeName], opts.preferredZone) && cfg.ZoneBonus > 0 {
+		if cfg.Debug {
+			log.Printf("Node %s is in preferred zone %s, adding bonus %.2f", nodeName, opts.preferredZone, cfg.ZoneBonus)
+		}
+		score = math.Min(maxScore, score+cfg.ZoneBonus)
+	}
+
+	// Steer replicas away from nodes already running their siblings
+	if penalty := spreadPenalty(cfg.SpreadPenalty, opts.siblings, nodeName, opts.maxSiblings); penalty > 0 {
+		if cfg.Debug {
+			log.Printf("Node %s runs %d siblings of the pod, applying spread penalty %.2f", nodeName, opts.siblings[nodeName], penalty)
+		}
+		score = math.Max(minScore, score-penalty)
+	}
+
+	// Canary nodes only get a bounded share of preference until an
+	// operator removes the annotation, however good their metrics look
+	if node := opts.nodes[nodeName]; node != nil && node.Annotations[canaryAnnotation] == "true" && score > cfg.CanaryScoreCap {
+		if cfg.Debug {
+			log.Printf("Node %s is a canary, capping score %.2f to %.2f", nodeName, score, cfg.CanaryScoreCap)
+		}
+		score = cfg.CanaryScoreCap
+	}
+
+	// Operator-set bounds, e.g. to keep GPU boxes from topping generic
+	// rankings. Applied before the disruption penalty so a floor can't
+	// keep a draining node attractive.
+	if floor, ceiling, ok := nodeScoreBounds(opts.nodes[nodeName]); ok {
+		bounded := math.Min(math.Max(score, floor), ceiling)
+		if cfg.Debug && bounded != score {
+			log.Printf("Node %s score %.2f clamped to label bounds [%.0f, %.0f]", nodeName, score, floor, ceiling)
+		}
+		score = bounded
+	}
+
+	// Keep pods off nodes that are being drained
+	if nodeDisrupted(opts.nodes[nodeName]) {
+		if cfg.Debug {
+			log.Printf("Node %s is cordoned or draining, applying penalty %.2f", nodeName, cfg.DisruptionPenalty)
+		}
+		score = math.Max(minScore, score-cfg.DisruptionPenalty)
+	}
+
+	return score
+}
+
+func (se *SchedulerExtender) computeNodeScore(cfg *ExtenderConfig, nodeName string, opts scoreOptions) float64 {
+	// Write lock since the computed score is stored back on the entry
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.computeNodeScoreLocked(cfg, nodeName, opts)
+}
+
+// computeNodeScoreLocked is computeNodeScore for callers holding se.mu.
+func (se *SchedulerExtender) computeNodeScoreLocked(cfg *ExtenderConfig, nodeName string, opts scoreOptions) float64 {
+	return se.scoreEntry(cfg, nodeName, se.metricsCache[nodeName], opts)
+}
+
+// scoreEntry computes the metric score of nodeName from metrics, which may
+// be nil, and stores the result on metrics. Callers scoring a live cache
+// entry must hold se.mu for writing.
+func (se *SchedulerExtender) scoreEntry(cfg *ExtenderConfig, nodeName string, metrics *NodeMetrics, opts scoreOptions) float64 {
+	if metrics == nil {
+		if cfg.AllocatableFallback {
+			if score, ok := allocatableScore(opts.nodes[nodeName], opts.capacity); ok {
+				if cfg.Debug {
+					log.Printf("No metrics found for node %s, scoring %.2f on allocatable", nodeName, score)
+				}
+				return score
+			}
+		}
+		if cfg.Debug {
 			log.Printf("No metrics found for node %s, using neutral score", nodeName)
 		}
-		return 50.0 // Neutral score
+		return cfg.NeutralScore
+	}
+
+	// Don't score confidently on data Prometheus hasn't refreshed in a while
+	if cfg.MaxMetricAge > 0 {
+		age := se.entryAge(cfg, metrics)
+		metrics.Stale = age > time.Duration(cfg.MaxMetricAge)*time.Second
+		if metrics.Stale {
+			if cfg.Debug {
+				log.Printf("Metrics for node %s are older than %ds, using neutral score", nodeName, cfg.MaxMetricAge)
+			}
+			return cfg.NeutralScore
+		}
+	}
+
+	weights := &cfg.Weights
+	if opts.profile != nil {
+		weights = &opts.profile.ScoreWeights
+
+		// A profile can't be trusted on a node lacking the metrics it
+		// depends on, whatever the others say
+		if missing := metrics.missingAny(opts.profile.RequiredMetrics); missing != "" {
+			if cfg.Debug {
+				log.Printf("Node %s lacks %s required by the pod's profile, using neutral score", nodeName, missing)
+			}
+			return cfg.NeutralScore
+		}
+	}
+	finalScore, components, ok := precomputedNodeScore(cfg, metrics, opts)
+	if !ok {
+		components = scoreComponents(cfg, nodeName, metrics, weights, opts.cpuRequestCores)
+		finalScore = scorers[cfg.Scorer].Score(metrics, cfg, components)
+	}
+
+	// Damp spikes from noisy metrics
+	if offset := smoothingOffset(cfg, metrics); offset != 0 {
+		if cfg.Debug {
+			log.Printf("Node %s score smoothed by %.2f", nodeName, offset)
+		}
+		finalScore += offset
+	}
+
+	// Prefer nodes holding steady over ones heading the wrong way
+	if penalty := trendPenalty(cfg, metrics, components); penalty > 0 {
+		if cfg.Debug {
+			log.Printf("Node %s metrics are worsening, applying trend penalty %.2f", nodeName, penalty)
+		}
+		finalScore -= penalty
 	}
+	finalScore = math.Min(math.Max(finalScore, minScore), maxScore)
 
-	// Normalize metrics and calculate weighted score
-	normalizedRTT := se.normalizeMetric(metrics.RTTp99, 0, 1000, true)
-	normalizedRetrans := se.normalizeMetric(metrics.RetransRate, 0, 100, true)
-	normalizedDrops := se.normalizeMetric(metrics.DropRate, 0, 1000, true)
-	normalizedRunqlat := se.normalizeMetric(metrics.RunqlatP95, 0, 100, true)
-	normalizedCPU := se.normalizeMetric(metrics.CPUUtil, 0, 100, true)
+	// Blend toward neutral until the node has enough observations
+	if ratio := se.warmupRatio(cfg, metrics.Observations); ratio < 1 {
+		finalScore = cfg.NeutralScore + ratio*(finalScore-cfg.NeutralScore)
+		if cfg.Debug {
+			log.Printf("Node %s warming up (%d/%d observations), score blended to %.2f",
+				nodeName, metrics.Observations, cfg.WarmupObservations, finalScore)
+		}
+	}
 
-	score := se.config.Weights.RTTp99*normalizedRTT +
-		se.config.Weights.RetransRate*normalizedRetrans +
-		se.config.Weights.DropRate*normalizedDrops +
-		se.config.Weights.RunqlatP95*normalizedRunqlat +
-		se.config.Weights.CPUUtil*normalizedCPU
+	// Trust the score less the older the data behind it
+	if ratio := se.freshnessRatio(cfg, metrics); ratio < 1 {
+		finalScore = cfg.NeutralScore + ratio*(finalScore-cfg.NeutralScore)
+		if cfg.Debug {
+			log.Printf("Node %s metrics have aged, score decayed to %.2f", nodeName, finalScore)
+		}
+	}
 
-	// Convert to 0-100 range
-	finalScore := score * 100.0
-	
 	// Store calculated score for debugging
 	metrics.Score = finalScore
+	metrics.Breakdown = components
 
 	return finalScore
 }
 
-func (se *SchedulerExtender) normalizeMetric(value, min, max float64, lowerIsBetter bool) float64 {
+// scaleCPUPenalty grows the cpu_util penalty (1 - normalized) with the
+// pod's CPU request relative to CPURequestReference. Pods without a
+// request are scored exactly as before.
+func scaleCPUPenalty(cfg *ExtenderConfig, normalized, cpuRequestCores float64) float64 {
+	if cpuRequestCores <= 0 || cfg.CPURequestReference <= 0 {
+		return normalized
+	}
+	scale := 1 + cpuRequestCores/cfg.CPURequestReference
+	return math.Max(0, 1-scale*(1-normalized))
+}
+
+// nodeScoreBounds reads the score floor and ceiling from node's labels.
+// Missing or invalid labels leave that side at the score range limit.
+func nodeScoreBounds(node *v1core.Node) (floor, ceiling float64, ok bool) {
+	floor, ceiling = minScore, maxScore
+	if node == nil {
+		return floor, ceiling, false
+	}
+	for label, bound := range map[string]*float64{minScoreLabel: &floor, maxScoreLabel: &ceiling} {
+		raw, set := node.Labels[label]
+		if !set {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < minScore || v > maxScore {
+			log.Printf("Ignoring invalid %s label %q on node %s", label, raw, node.Name)
+			continue
+		}
+		*bound = v
+		ok = true
+	}
+	if floor > ceiling {
+		log.Printf("Ignoring score bounds on node %s: %s is above %s", node.Name, minScoreLabel, maxScoreLabel)
+		return minScore, maxScore, false
+	}
+	return floor, ceiling, ok
+}
+
+// podCPURequestCores returns the CPU the scheduler will reserve for pod:
+// the sum over containers, or the largest init container if higher.
+func podCPURequestCores(pod *v1core.Pod) float64 {
+	if pod == nil {
+		return 0
+	}
+
+	total := 0.0
+	for _, c := range pod.Spec.Containers {
+		if q, ok := c.Resources.Requests[v1core.ResourceCPU]; ok {
+			total += q.AsApproximateFloat64()
+		}
+	}
+	for _, c := range pod.Spec.InitContainers {
+		if q, ok := c.Resources.Requests[v1core.ResourceCPU]; ok && q.AsApproximateFloat64() > total {
+			total = q.AsApproximateFloat64()
+		}
+	}
+	return total
+}
+
+// warmupRatio returns how much of a node's computed score to trust, rising
+// linearly from 0 to 1 over WarmupObservations refreshes.
+func (se *SchedulerExtender) warmupRatio(cfg *ExtenderConfig, observations int) float64 {
+	if cfg.WarmupObservations <= 0 || observations >= cfg.WarmupObservations {
+		return 1
+	}
+	return float64(observations) / float64(cfg.WarmupObservations)
+}
+
+// warmupRemaining returns how much of WarmupPeriod is left.
+func (se *SchedulerExtender) warmupRemaining(cfg *ExtenderConfig) time.Duration {
+	if cfg.WarmupPeriod <= 0 || se.startedAt.IsZero() {
+		return 0
+	}
+	return se.startedAt.Add(time.Duration(cfg.WarmupPeriod) * time.Second).Sub(se.clock.Now())
+}
+
+// freshnessRatio returns how much of a node's computed score to trust
+// given the age of its metrics, halving every MetricHalfLife seconds.
+func (se *SchedulerExtender) freshnessRatio(cfg *ExtenderConfig, metrics *NodeMetrics) float64 {
+	if cfg.MetricHalfLife <= 0 {
+		return 1
+	}
+	age := se.entryAge(cfg, metrics)
+	if age <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, age.Seconds()/float64(cfg.MetricHalfLife))
+}
+
+// Normalization scales for MetricScaling.
+const (
+	scalingLinear = "linear"
+	scalingLog    = "log"
+)
+
+// normalizeMetric maps value onto 0-1 within [min, max], 1 being best.
+// With logScale the position is taken on log(1+x) of the offset from min,
+// so 5ms vs 50ms matters as much as 50ms vs 500ms.
+func normalizeMetric(value, min, max float64, lowerIsBetter, logScale bool) float64 {
 	if max == min {
 		return 0.5
 	}
 
+	// NaN/Inf would poison the weighted sum, so score them as worst case
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0
+	}
+
 	if value < min {
 		value = min
 	}
@@ -197,7 +1070,10 @@ func (se *SchedulerExtender) normalizeMetric(value, min, max float64, lowerIsBet
 	}
 
 	normalized := (value - min) / (max - min)
-	
+	if logScale {
+		normalized = math.Log1p(value-min) / math.Log1p(max-min)
+	}
+
 	if lowerIsBetter {
 		normalized = 1.0 - normalized
 	}
@@ -205,43 +1081,71 @@ func (se *SchedulerExtender) normalizeMetric(value, min, max float64, lowerIsBet
 	return normalized
 }
 
-func (se *SchedulerExtender) updateMetrics(ctx context.Context) error {
-	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+// fetchMetrics queries Prometheus and builds fresh NodeMetrics without
+// touching the cache. matchers, if any, are added to every query on top
+// of the configured QueryLabels.
+func (se *SchedulerExtender) fetchMetrics(ctx context.Context, cfg *ExtenderConfig, matchers map[string]string) (map[string]*NodeMetrics, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.QueryTimeoutSeconds)*time.Second)
 	defer cancel()
 
-	queries := map[string]string{
-		"rtt_p99":      "ebpf_rtt_p99_milliseconds",
-		"retrans_rate": "ebpf_tcp_retrans_rate",
-		"drop_rate":    "ebpf_drop_rate",
-		"runqlat_p95":  "ebpf_runqlat_p95_milliseconds",
-		"cpu_util":     "ebpf_cpu_utilization",
-	}
+	metricsData := make(map[string]map[string][]float64)
+	sampleCounts := make(map[string]map[string][]float64)
+	quirks := quirksFor(cfg.Backend)
+	window, _ := time.ParseDuration(cfg.SmoothingWindow)
+	now := se.clock.Now()
+	queried := 0
 
-	metricsData := make(map[string]map[string]float64)
+	specs := se.neededSpecs(cfg)
+	for _, spec := range specs {
+		// Give up on the rest once the caller has gone away or we're shutting down
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		queried++
+		query := se.buildQuery(cfg, spec.query, matchers)
 
-	for metricName, query := range queries {
-		result, _, err := se.promClient.Query(timeoutCtx, query, time.Now())
+		// Wait for a token within the refresh deadline rather than failing
+		if err := se.queryLimiter.Wait(timeoutCtx); err != nil {
+			log.Printf("Rate limit wait for %s aborted: %v", spec.name, err)
+			continue
+		}
+
+		values, err := se.queryMetric(timeoutCtx, cfg, spec.name, query, window, now, quirks)
 		if err != nil {
-			log.Printf("Failed to query %s: %v", metricName, err)
+			log.Printf("Failed to query %s: %v", spec.name, err)
 			continue
 		}
+		// An empty answer usually means the recording rule isn't deployed
+		if fallback, ok := cfg.FallbackQueries[spec.name]; ok && len(values) == 0 {
+			if cfg.Debug {
+				log.Printf("Query for %s returned nothing, trying its fallback query", spec.name)
+			}
+			values, err = se.queryPaced(timeoutCtx, cfg, spec.name+"_fallback", se.buildQuery(cfg, fallback, matchers), window, now, quirks)
+			if err != nil {
+				log.Printf("Failed to query %s fallback: %v", spec.name, err)
+				continue
+			}
+		}
+		metricsData[spec.name] = values
 
-		nodeValues := make(map[string]float64)
-		// Simplified Prometheus result parsing
-		// In production, you'd need proper parsing based on the result type
-		if vectors, ok := result.(map[string]interface{}); ok {
-			for nodeName, value := range vectors {
-				if val, ok := value.(float64); ok {
-					nodeValues[nodeName] = val
-				}
+		if countQuery, ok := cfg.SampleCountQueries[spec.name]; ok {
+			counts, err := se.queryPaced(timeoutCtx, cfg, spec.name+"_sample_count", se.buildQuery(cfg, countQuery, matchers), window, now, quirks)
+			if err != nil {
+				log.Printf("Failed to query sample counts for %s: %v", spec.name, err)
+				continue
 			}
+			sampleCounts[spec.name] = counts
 		}
-		metricsData[metricName] = nodeValues
 	}
 
-	// Build new metrics cache
-	newCache := make(map[string]*NodeMetrics)
-	
+	// Let callers keep their data when Prometheus couldn't be reached at all
+	if queried > 0 && len(metricsData) == 0 {
+		return nil, fmt.Errorf("all %d metric queries failed", queried)
+	}
+
+	foldNodeGroups(cfg.NodeGroups, metricsData)
+	foldNodeGroups(cfg.NodeGroups, sampleCounts)
+
 	// Get all unique node names
 	nodeNames := make(map[string]bool)
 	for _, nodeValues := range metricsData {
@@ -250,92 +1154,608 @@ func (se *SchedulerExtender) updateMetrics(ctx context.Context) error {
 		}
 	}
 
+	newCache := make(map[string]*NodeMetrics)
 	for nodeName := range nodeNames {
 		metrics := &NodeMetrics{
-			NodeName:  nodeName,
-			Timestamp: time.Now().Unix(),
+			NodeName:     nodeName,
+			Timestamp:    now.Unix(),
+			Observations: 1,
+			fetchedAt:    now,
+		}
+
+		for _, spec := range specs {
+			samples, exists := metricsData[spec.name][nodeName]
+			if !exists {
+				metrics.Missing = append(metrics.Missing, spec.name)
+				continue
+			}
+			val, err := aggregate(se.aggregationFor(cfg, spec), samples)
+			if err != nil {
+				log.Printf("Failed to aggregate %s for node %s: %v", spec.name, nodeName, err)
+				metrics.Missing = append(metrics.Missing, spec.name)
+				continue
+			}
+			spec.set(metrics, val)
+
+			if counts, ok := sampleCounts[spec.name][nodeName]; ok {
+				if metrics.SampleCounts == nil {
+					metrics.SampleCounts = make(map[string]float64)
+				}
+				metrics.SampleCounts[spec.name], _ = aggregate(aggSum, counts)
+			}
+		}
+
+		newCache[nodeName] = metrics
+	}
+
+	return newCache, nil
+}
+
+func (se *SchedulerExtender) updateMetrics(ctx context.Context, cfg *ExtenderConfig) (err error) {
+	ctx, span := tracer.Start(ctx, "updateMetrics")
+	defer func() { endSpan(span, err) }()
+
+	newCache, err := se.fetchMetrics(ctx, cfg, nil)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(nodeCountAttr(len(newCache)))
+
+	se.mu.RLock()
+	previous := se.metricsCache
+	se.mu.RUnlock()
+
+	specs := se.neededSpecs(cfg)
+
+	for nodeName, metrics := range newCache {
+		prev, ok := previous[nodeName]
+		if ok {
+			metrics.Observations = prev.Observations + 1
+		}
+		if cfg.TrendPenalty > 0 {
+			recordTrend(cfg, specs, metrics, prev)
+		}
+	}
+
+	precomputeScores(cfg, newCache)
+	smoothScores(cfg, newCache, previous)
+
+	se.mu.Lock()
+	se.metricsCache = newCache
+	se.lastUpdate = se.clock.Now()
+	se.generation++
+	se.mu.Unlock()
+	se.ready.Store(true)
+	se.history.prune(newCache)
+
+	if cfg.Debug {
+		log.Printf("Updated metrics cache for %d nodes", len(newCache))
+	}
+
+	if cfg.CacheFile != "" {
+		if err := se.saveCacheFile(cfg, newCache); err != nil {
+			log.Printf("Failed to write cache file %s: %v", cfg.CacheFile, err)
 		}
+	}
 
-		if val, exists := metricsData["rtt_p99"][nodeName]; exists {
-			metrics.RTTp99 = val
+	return nil
+}
+
+// queryMetric runs one metric query, averaging over window when set, and
+// returns the values per node. Slow queries are hedged when HedgeDelay is
+// set, as long as the rate limit has room for the extra query.
+func (se *SchedulerExtender) queryMetric(ctx context.Context, cfg *ExtenderConfig, name, query string, window time.Duration, now time.Time, quirks backendQuirks) (values map[string][]float64, err error) {
+	ctx, span := tracer.Start(ctx, "prometheus.query", trace.WithAttributes(
+		attribute.String("metric", name),
+		attribute.String("query", query),
+	))
+	defer func() { endSpan(span, err) }()
+
+	hedgeDelay := time.Duration(cfg.HedgeDelay) * time.Millisecond
+	if window > 0 {
+		result, err := hedged(ctx, hedgeDelay, se.queryLimiter.Allow, func(ctx context.Context) (model.Value, error) {
+			result, warnings, err := se.promClient.QueryRange(ctx, query, v1.Range{
+				Start: now.Add(-window),
+				End:   now,
+				Step:  smoothingStep(window),
+			})
+			return result, se.checkWarnings(cfg, name, warnings, err)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("over %s: %w", window, err)
 		}
-		if val, exists := metricsData["retrans_rate"][nodeName]; exists {
-			metrics.RetransRate = val
+		return parseNodeAverages(result, quirks), nil
+	}
+
+	result, err := hedged(ctx, hedgeDelay, se.queryLimiter.Allow, func(ctx context.Context) (model.Value, error) {
+		result, warnings, err := se.promClient.Query(ctx, query, now)
+		return result, se.checkWarnings(cfg, name, warnings, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseNodeValues(result, quirks), nil
+}
+
+// queryPaced is queryMetric after waiting for a query rate limit token.
+func (se *SchedulerExtender) queryPaced(ctx context.Context, cfg *ExtenderConfig, name, query string, window time.Duration, now time.Time, quirks backendQuirks) (map[string][]float64, error) {
+	if err := se.queryLimiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return se.queryMetric(ctx, cfg, name, query, window, now, quirks)
+}
+
+// buildQuery scopes a metric query with QueryLabels and any extra matchers.
+func (se *SchedulerExtender) buildQuery(cfg *ExtenderConfig, query string, matchers map[string]string) string {
+	all := make(map[string]string, len(cfg.QueryLabels)+len(matchers))
+	for k, v := range cfg.QueryLabels {
+		all[k] = v
+	}
+	for k, v := range matchers {
+		all[k] = v
+	}
+	return injectMatchers(query, all)
+}
+
+// smoothingStep spreads roughly smoothingPoints samples across the window.
+func smoothingStep(window time.Duration) time.Duration {
+	step := window / smoothingPoints
+	if step < time.Second {
+		step = time.Second
+	}
+	return step
+}
+
+// totalWeight sums the absolute weights of every enabled metric.
+func totalWeight(cfg *ExtenderConfig, weights *ScoreWeights) float64 {
+	total := 0.0
+	for _, spec := range metricSpecs {
+		if !cfg.disabledMetrics[spec.name] {
+			total += math.Abs(*spec.weight(weights))
 		}
-		if val, exists := metricsData["drop_rate"][nodeName]; exists {
-			metrics.DropRate = val
+	}
+	for _, c := range cfg.CustomMetrics {
+		if !cfg.disabledMetrics[c.Name] {
+			total += math.Abs(c.Weight)
 		}
-		if val, exists := metricsData["runqlat_p95"][nodeName]; exists {
-			metrics.RunqlatP95 = val
+	}
+	return total
+}
+
+// neededSpecs returns the metrics to query: built-in metrics used for
+// scoring or filtering and weighted custom metrics. Metrics neither scored
+// nor filtered on don't need a query.
+func (se *SchedulerExtender) neededSpecs(cfg *ExtenderConfig) []metricSpec {
+	var specs []metricSpec
+	for _, spec := range metricSpecs {
+		if se.metricNeeded(cfg, spec) {
+			specs = append(specs, spec)
 		}
-		if val, exists := metricsData["cpu_util"][nodeName]; exists {
-			metrics.CPUUtil = val
+	}
+	for i := range cfg.CustomMetrics {
+		c := &cfg.CustomMetrics[i]
+		if _, filtered := cfg.FilterThresholds[c.Name]; (c.Weight != 0 || filtered) && !cfg.disabledMetrics[c.Name] {
+			specs = append(specs, c.spec())
 		}
+	}
+	return specs
+}
 
-		newCache[nodeName] = metrics
+// metricNeeded reports whether a metric is used for scoring or filtering.
+func (se *SchedulerExtender) metricNeeded(cfg *ExtenderConfig, spec metricSpec) bool {
+	if cfg.disabledMetrics[spec.name] {
+		return false
+	}
+	if *spec.weight(&cfg.Weights) != 0 {
+		return true
+	}
+	for _, profile := range cfg.Profiles {
+		if *spec.weight(&profile.ScoreWeights) != 0 {
+			return true
+		}
+		for _, name := range profile.RequiredMetrics {
+			if name == spec.name {
+				return true
+			}
+		}
 	}
+	if cfg.filterExpr != nil && cfg.filterExpr.metrics[spec.name] {
+		return true
+	}
+	_, filtered := cfg.FilterThresholds[spec.name]
+	return filtered
+}
 
-	se.metricsCache = newCache
-	se.lastUpdate = time.Now()
+// missingAny returns the first of names the entry has no value for, or "".
+func (m *NodeMetrics) missingAny(names []string) string {
+	for _, name := range names {
+		for _, missing := range m.Missing {
+			if name == missing {
+				return name
+			}
+		}
+	}
+	return ""
+}
 
-	if se.config.Debug {
-		log.Printf("Updated metrics cache for %d nodes", len(newCache))
+// get returns m's value of the metric and whether m has one.
+func (spec metricSpec) get(m *NodeMetrics) (float64, bool) {
+	if spec.value != nil {
+		return *spec.value(m), m.missingAny([]string{spec.name}) == ""
 	}
+	value, ok := m.Custom[spec.name]
+	return value, ok
+}
 
+// set stores a fetched value on m, in Custom for custom metrics.
+func (spec metricSpec) set(m *NodeMetrics, value float64) {
+	if spec.value != nil {
+		*spec.value(m) = value
+		return
+	}
+	if m.Custom == nil {
+		m.Custom = make(map[string]float64)
+	}
+	m.Custom[spec.name] = value
+}
+
+// worst returns the value at the bad end of the metric's normalization range.
+func (spec metricSpec) worst() float64 {
+	if spec.lowerIsBetter {
+		return spec.max
+	}
+	return spec.min
+}
+
+// aggregationFor returns the configured aggregation for a metric, or its default.
+func (se *SchedulerExtender) aggregationFor(cfg *ExtenderConfig, spec metricSpec) string {
+	if agg, ok := cfg.Aggregations[spec.name]; ok {
+		return agg
+	}
+	return spec.aggregation
+}
+
+func lookupMetricSpec(name string) *metricSpec {
+	for i := range metricSpecs {
+		if metricSpecs[i].name == name {
+			return &metricSpecs[i]
+		}
+	}
 	return nil
 }
 
+// refreshIfExpired updates the cache when it is older than CacheTTL,
+// continuing with cached data if the refresh fails.
+func (se *SchedulerExtender) refreshIfExpired(ctx context.Context) {
+	if err := se.refresh(ctx); err != nil {
+		log.Printf("Failed to update metrics: %v", err)
+	}
+}
+
+// refresh runs updateMetrics if the cache has expired. Only one refresh is
+// in flight at a time; callers arriving meanwhile continue with cached
+// data. Refreshes never start closer together than the refresh floor,
+// whatever triggers them.
+func (se *SchedulerExtender) refresh(ctx context.Context) error {
+	cfg := se.config.Load()
+	if !se.cacheExpired(cfg) {
+		return nil
+	}
+	if !se.refreshMu.TryLock() {
+		return nil
+	}
+	defer se.refreshMu.Unlock()
+
+	if !se.lastRefresh.IsZero() && se.since(cfg, se.lastRefresh) < se.refreshFloor(cfg) {
+		return nil
+	}
+	se.lastRefresh = se.clock.Now()
+
+	return se.updateMetrics(ctx, cfg)
+}
+
+// refreshFloor is the minimum interval between two refreshes.
+func (se *SchedulerExtender) refreshFloor(cfg *ExtenderConfig) time.Duration {
+	return time.Duration(cfg.MinRefreshInterval) * time.Second
+}
+
+// cacheExpired reports whether the cache is older than CacheTTL.
+func (se *SchedulerExtender) cacheExpired(cfg *ExtenderConfig) bool {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	return se.since(cfg, se.lastUpdate) > time.Duration(cfg.CacheTTL)*time.Second
+}
+
+// cacheEmptyAndExpired reports whether we have no metrics at all, not even
+// stale ones to fall back on.
+func (se *SchedulerExtender) cacheEmptyAndExpired(cfg *ExtenderConfig) bool {
+	se.mu.RLock()
+	empty := len(se.metricsCache) == 0
+	se.mu.RUnlock()
+	return empty && se.cacheExpired(cfg)
+}
+
+// refreshLoop keeps the cache warm independently of scheduling requests,
+// so readiness doesn't depend on the scheduler calling us first.
+func (se *SchedulerExtender) refreshLoop(ctx context.Context) {
+	for {
+		if err := se.refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Background metrics refresh failed: %v", err)
+		}
+
+		// Re-read the interval each round so a reload takes effect
+		timer := time.NewTimer(se.refreshInterval(se.config.Load()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// refreshInterval is how often refreshLoop wakes up.
+func (se *SchedulerExtender) refreshInterval(cfg *ExtenderConfig) time.Duration {
+	interval := time.Duration(cfg.CacheTTL) * time.Second
+	if floor := se.refreshFloor(cfg); interval < floor {
+		interval = floor
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return interval
+}
+
+// metricsHandler dumps the metrics cache. ?node=a,b restricts it to the
+// named nodes, and ?limit= and ?offset= page through nodes in name order.
+// The total number of matching nodes is in X-Total-Count.
 func (se *SchedulerExtender) metricsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(se.metricsCache)
+	query := r.URL.Query()
+	limit, offset := -1, 0
+	for param, dst := range map[string]*int{"limit": &limit, "offset": &offset} {
+		if v := query.Get(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid %s %q", param, v), http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+
+	cfg := se.config.Load()
+	if query.Get("node") == "" && limit < 0 && offset == 0 {
+		writeConditionalJSON(w, r, cfg, se.metricsCache, se.lastUpdate)
+		return
+	}
+
+	var names []string
+	if nodes := query.Get("node"); nodes != "" {
+		for _, name := range strings.Split(nodes, ",") {
+			if _, ok := se.metricsCache[name]; ok {
+				names = append(names, name)
+			}
+		}
+	} else {
+		for name := range se.metricsCache {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(names)))
+
+	names = names[min(offset, len(names)):]
+	if limit >= 0 {
+		names = names[:min(limit, len(names))]
+	}
+	page := make(map[string]*NodeMetrics, len(names))
+	for _, name := range names {
+		page[name] = se.metricsCache[name]
+	}
+	writeConditionalJSON(w, r, cfg, page, se.lastUpdate)
 }
 
+type healthResponse struct {
+	Status        string     `json:"status"`
+	Version       string     `json:"version"`
+	ConfigVersion string     `json:"config_version"`
+	LastRefresh   *time.Time `json:"last_refresh"`
+	Drained       bool       `json:"drained"`
+}
+
+// healthHandler is a liveness check answering OK, or "OK (drained)" in
+// drained mode. With ?verbose=true it also reports the binary and config
+// versions and when metrics were last refreshed.
 func (se *SchedulerExtender) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose")); !verbose {
+		w.WriteHeader(http.StatusOK)
+		if se.drained.Load() {
+			w.Write([]byte("OK (drained)"))
+		} else {
+			w.Write([]byte("OK"))
+		}
+		return
+	}
+
+	resp := healthResponse{
+		Status:        "OK",
+		Version:       version,
+		ConfigVersion: se.config.Load().version,
+		Drained:       se.drained.Load(),
+	}
+	se.mu.RLock()
+	if !se.lastUpdate.IsZero() {
+		lastUpdate := se.lastUpdate
+		resp.LastRefresh = &lastUpdate
+	}
+	se.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// readyzHandler reports ready only once metrics have been fetched from
+// Prometheus or restored from a cache file, unlike healthHandler which is
+// a pure liveness check.
+func (se *SchedulerExtender) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !se.ready.Load() {
+		http.Error(w, "metrics not yet loaded", http.StatusServiceUnavailable)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
+type verifyResponse struct {
+	Node         string       `json:"node"`
+	Live         *NodeMetrics `json:"live"`
+	Cached       *NodeMetrics `json:"cached"`
+	CachedAgeSec *float64     `json:"cached_age_seconds"`
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
+// verifyHandler queries Prometheus for a single node and returns the live
+// values next to the cached ones, without modifying the cache.
+func (se *SchedulerExtender) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	nodeName := r.URL.Query().Get("node")
+	if nodeName == "" {
+		http.Error(w, "missing node parameter", http.StatusBadRequest)
+		return
 	}
-	return defaultValue
+
+	cfg := se.config.Load()
+	live, err := se.fetchMetrics(r.Context(), cfg, map[string]string{nodeLabel: nodeName})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to query metrics: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	resp := verifyResponse{Node: nodeName, Live: live[nodeName]}
+
+	se.mu.RLock()
+	if cached, ok := se.metricsCache[nodeName]; ok {
+		// Copy so the response isn't racing with scoring
+		entry := *cached
+		resp.Cached = &entry
+		age := se.entryAge(cfg, cached).Seconds()
+		resp.CachedAgeSec = &age
+	}
+	se.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		if boolValue, err := strconv.ParseBool(value); err == nil {
-			return boolValue
-		}
+// routes builds the scheduler-facing mux and the observability mux. They
+// are the same mux unless MetricsAddr is set.
+func (se *SchedulerExtender) routes(cfg *ExtenderConfig) (schedulerMux, metricsMux *http.ServeMux) {
+	schedulerMux = http.NewServeMux()
+	schedulerMux.HandleFunc("/filter", withGzip(se.withAccessLog(withRecovery(se.withLoadShedding(se.filter)))))
+	schedulerMux.HandleFunc("/prioritize", withGzip(se.withAccessLog(withRecovery(se.withLoadShedding(se.prioritize)))))
+	schedulerMux.HandleFunc("/preempt", withGzip(se.withAccessLog(withRecovery(se.withLoadShedding(se.preempt)))))
+	schedulerMux.HandleFunc("/reload", se.reloadHandler)
+	schedulerMux.HandleFunc("/drain", se.drainHandler(true))
+	schedulerMux.HandleFunc("/inject", se.injectHandler)
+	schedulerMux.HandleFunc("/undrain", se.drainHandler(false))
+	schedulerMux.HandleFunc("/cache/clear", se.clearCacheHandler)
+
+	metricsMux = schedulerMux
+	if cfg.MetricsAddr != "" {
+		metricsMux = http.NewServeMux()
 	}
-	return defaultValue
+	metricsMux.HandleFunc("/metrics", se.metricsHandler)
+	metricsMux.Handle("/prom-metrics", se.promMetrics.handler())
+	metricsMux.HandleFunc("/health", se.healthHandler)
+	metricsMux.HandleFunc("/readyz", se.readyzHandler)
+	metricsMux.HandleFunc("/verify", se.verifyHandler)
+	metricsMux.HandleFunc("/history", se.historyHandler)
+	metricsMux.HandleFunc("/worst", se.worstHandler)
+	metricsMux.HandleFunc("/config", se.configHandler)
+	metricsMux.HandleFunc("/snapshot", se.snapshotHandler)
+
+	return schedulerMux, metricsMux
 }
 
 func main() {
+	simulate := flag.String("simulate", "", "score a metrics JSON dump with the current config, print the ranking and exit")
+	flag.Parse()
+
+	if *simulate != "" {
+		if err := runSimulation(*simulate, os.Stdout); err != nil {
+			log.Fatalf("Simulation failed: %v", err)
+		}
+		return
+	}
+
 	extender, err := NewSchedulerExtender()
 	if err != nil {
 		log.Fatalf("Failed to create scheduler extender: %v", err)
 	}
 
-	// Setup HTTP routes
-	http.HandleFunc("/filter", extender.filter)
-	http.HandleFunc("/prioritize", extender.prioritize)
-	http.HandleFunc("/metrics", extender.metricsHandler)
-	http.HandleFunc("/health", extender.healthHandler)
+	// Cancelled on SIGTERM so in-flight Prometheus queries abort promptly
+	// instead of eating into the grace period
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	cfg := extender.config.Load()
+	shutdownTracing, err := initTracing(ctx, cfg.TracingEndpoint, cfg.TracingInsecure)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	if cfg.AnnotatePlacement {
+		if extender.kubeClient == nil {
+			log.Printf("Placement annotations need the Kubernetes API, which is unavailable")
+		} else {
+			go extender.annotatePlacements(ctx)
+		}
+	}
+
+	refreshDone := make(chan struct{})
+	go func() {
+		defer close(refreshDone)
+		extender.refreshLoop(ctx)
+	}()
+
+	schedulerMux, metricsMux := extender.routes(cfg)
+	servers := []*http.Server{{Addr: cfg.SchedulerAddr, Handler: schedulerMux}}
+	if cfg.MetricsAddr != "" {
+		servers = append(servers, &http.Server{Addr: cfg.MetricsAddr, Handler: metricsMux})
+	}
 
-	addr := fmt.Sprintf(":%d", extender.config.Port)
-	log.Printf("Starting scheduler extender on %s", addr)
-	
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	serveErr := make(chan error, len(servers))
+	for _, server := range servers {
+		// Requests inherit ctx, so refreshes they trigger are cancelled too
+		server.BaseContext = func(net.Listener) context.Context { return ctx }
+		log.Printf("Starting scheduler extender on %s", server.Addr)
+		go func(server *http.Server) { serveErr <- server.ListenAndServe() }(server)
+	}
+
+	select {
+	case err := <-serveErr:
 		log.Fatalf("Failed to start server: %v", err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down")
+	grace := time.Duration(extender.config.Load().ShutdownGracePeriod) * time.Second
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP server shutdown on %s: %v", server.Addr, err)
+		}
+	}
+	select {
+	case <-refreshDone:
+	case <-shutdownCtx.Done():
+		log.Printf("Metrics refresh did not stop within %s", grace)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
 	}
 }
@@ -0,0 +1,938 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"golang.org/x/time/rate"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestMain(m *testing.M) {
+	// Scoring logs per node; keep test output readable
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeClock is a Clock tests move by hand.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testConfig returns the default configuration with mutate applied and
+// validated like loadConfig does.
+func testConfig(t testing.TB, mutate func(*ExtenderConfig)) *ExtenderConfig {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Debug = false
+	cfg.CacheTTL = 3600
+	if mutate != nil {
+		mutate(cfg)
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig: %v", err)
+	}
+	cfg.version = configVersion(cfg)
+	return cfg
+}
+
+// promSeries answers a query with per-node values when the query
+// contains the key; the first matching key wins.
+type promSeries map[string]map[string]float64
+
+// fakePrometheus serves instant and range queries from series, attaching
+// warnings to every answer.
+func fakePrometheus(t testing.TB, series promSeries, warnings ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		query := r.Form.Get("query")
+		rangeQuery := strings.HasSuffix(r.URL.Path, "query_range")
+
+		results := []map[string]interface{}{}
+		for key, nodes := range series {
+			if !strings.Contains(query, key) {
+				continue
+			}
+			for node, value := range nodes {
+				sample := []interface{}{float64(time.Now().Unix()), fmt.Sprint(value)}
+				result := map[string]interface{}{"metric": map[string]string{nodeLabel: node}}
+				if rangeQuery {
+					result["values"] = []interface{}{sample}
+				} else {
+					result["value"] = sample
+				}
+				results = append(results, result)
+			}
+			break
+		}
+		resultType := "vector"
+		if rangeQuery {
+			resultType = "matrix"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "success",
+			"warnings": warnings,
+			"data":     map[string]interface{}{"resultType": resultType, "result": results},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestExtender builds an extender around cfg without a cluster. Its
+// Prometheus answers from series.
+func newTestExtender(t testing.TB, cfg *ExtenderConfig, series promSeries, warnings ...string) *SchedulerExtender {
+	t.Helper()
+	client, err := api.NewClient(api.Config{Address: fakePrometheus(t, series, warnings...).URL})
+	if err != nil {
+		t.Fatalf("api.NewClient: %v", err)
+	}
+	se := &SchedulerExtender{
+		metricsCache: make(map[string]*NodeMetrics),
+		promClient:   v1.NewAPI(client),
+		clock:        realClock{},
+		accessLog:    newAccessLogger(),
+		podScores:    newPodScoreCache(),
+		dedupe:       newDedupeCache(),
+		history:      newScoreHistory(cfg.HistoryMaxEntries),
+		queryLimiter: rate.NewLimiter(rate.Inf, 1),
+		promMetrics:  newExtenderMetrics(),
+	}
+	se.config.Store(cfg)
+	return se
+}
+
+// seedCache installs entries as a fresh refresh would.
+func (se *SchedulerExtender) seedCache(cfg *ExtenderConfig, entries ...*NodeMetrics) {
+	cache := make(map[string]*NodeMetrics, len(entries))
+	now := se.clock.Now()
+	for _, m := range entries {
+		if m.Timestamp == 0 {
+			m.Timestamp = now.Unix()
+		}
+		if m.Observations == 0 {
+			m.Observations = 1
+		}
+		cache[m.NodeName] = m
+	}
+	precomputeScores(cfg, cache)
+
+	se.mu.Lock()
+	se.metricsCache = cache
+	se.lastUpdate = now
+	se.generation++
+	se.mu.Unlock()
+	se.ready.Store(true)
+}
+
+func testPod(name string, annotations map[string]string) *v1core.Pod {
+	return &v1core.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "default",
+		Name:        name,
+		UID:         k8stypes.UID("uid-" + name),
+		Annotations: annotations,
+	}}
+}
+
+func extenderArgs(pod *v1core.Pod, nodeNames ...string) []byte {
+	body, _ := json.Marshal(extenderv1.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	return body
+}
+
+// callPrioritize posts body to prioritize and decodes the ranking.
+func callPrioritize(t testing.TB, se *SchedulerExtender, body []byte) extenderv1.HostPriorityList {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	se.prioritize(rec, httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("prioritize status %d: %s", rec.Code, rec.Body)
+	}
+	var result extenderv1.HostPriorityList
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decoding prioritize response: %v", err)
+	}
+	return result
+}
+
+// callFilter posts body to filter and decodes the result.
+func callFilter(t testing.TB, se *SchedulerExtender, body []byte) extenderv1.ExtenderFilterResult {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	se.filter(rec, httptest.NewRequest(http.MethodPost, "/filter", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("filter status %d: %s", rec.Code, rec.Body)
+	}
+	var result extenderv1.ExtenderFilterResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decoding filter response: %v", err)
+	}
+	return result
+}
+
+func scoresByHost(result extenderv1.HostPriorityList) map[string]int64 {
+	scores := make(map[string]int64, len(result))
+	for _, hp := range result {
+		scores[hp.Host] = hp.Score
+	}
+	return scores
+}
+
+func FuzzPrioritize(f *testing.F) {
+	f.Add(extenderArgs(testPod("web", nil), "a", "b"))
+	f.Add(extenderArgs(testPod("web", map[string]string{profileAnnotation: "missing"}), "a", "unknown"))
+	f.Add([]byte(`{"pod":{"metadata":{"name":"p"}},"nodes":{"items":[{"metadata":{"name":"a","annotations":{"ebpf-scheduler/canary":"true"}}}]}}`))
+	f.Add([]byte(`{"pod":null,"nodenames":[]}`))
+	f.Add([]byte(`{"nodenames":["a","a",""]}`))
+	f.Add([]byte(`not json`))
+
+	cfg := testConfig(f, func(cfg *ExtenderConfig) {
+		cfg.PodScoreCache = true
+		cfg.ScoreJitter = 2
+	})
+	se := newTestExtender(f, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "a", RTTp99: 5, CPUUtil: 20},
+		&NodeMetrics{NodeName: "b", RTTp99: 800, CPUUtil: 95, DropRate: 3},
+	)
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		rec := httptest.NewRecorder()
+		se.prioritize(rec, httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(body)))
+
+		var args extenderv1.ExtenderArgs
+		valid := json.NewDecoder(bytes.NewReader(body)).Decode(&args) == nil && validateArgs(&args) == nil
+		if !valid {
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("invalid request got status %d", rec.Code)
+			}
+			return
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		var result extenderv1.HostPriorityList
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("response is not a host priority list: %v: %s", err, rec.Body)
+		}
+		if len(result) != len(candidateNodeNames(&args)) {
+			t.Fatalf("%d scores for %d candidates", len(result), len(candidateNodeNames(&args)))
+		}
+		for _, hp := range result {
+			if hp.Score < minScore || hp.Score > maxScore {
+				t.Fatalf("node %q scored %d, outside %v-%v", hp.Host, hp.Score, minScore, maxScore)
+			}
+		}
+	})
+}
+
+func TestReadyzAfterFirstRefresh(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 10}})
+
+	probe := func(handler http.HandlerFunc, path string) int {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	if code := probe(se.readyzHandler, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before the first refresh: status %d, want 503", code)
+	}
+	if code := probe(se.healthHandler, "/health"); code != http.StatusOK {
+		t.Errorf("/health before the first refresh: status %d, want 200", code)
+	}
+
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+	if code := probe(se.readyzHandler, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz after a refresh: status %d, want 200", code)
+	}
+}
+
+// getMetrics fetches /metrics with the given query string.
+func getMetrics(t *testing.T, se *SchedulerExtender, query string) (map[string]*NodeMetrics, *httptest.ResponseRecorder) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	se.metricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics"+query, nil))
+	var nodes map[string]*NodeMetrics
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &nodes); err != nil {
+			t.Fatalf("decoding /metrics: %v: %s", err, rec.Body)
+		}
+	}
+	return nodes, rec
+}
+
+func TestMetricsShowScoreBreakdown(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 250, RetransRate: 10, DropRate: 100, RunqlatP95: 20, CPUUtil: 60})
+	score := se.calculateNodeScore(cfg, "a", scoreOptions{})
+
+	nodes, _ := getMetrics(t, se, "")
+	breakdown := nodes["a"].Breakdown
+	for _, metric := range []string{"rtt_p99", "retrans_rate", "drop_rate", "runqlat_p95", "cpu_util"} {
+		c, ok := breakdown[metric]
+		if !ok {
+			t.Errorf("no breakdown for %s", metric)
+			continue
+		}
+		if c.Weight <= 0 || c.Normalized <= 0 || c.Normalized >= 1 || c.Contribution <= 0 {
+			t.Errorf("%s breakdown = %+v", metric, c)
+		}
+	}
+	if c := breakdown["rtt_p99"]; c.Value != 250 || math.Abs(c.Normalized-0.75) > 1e-9 {
+		t.Errorf("rtt_p99 breakdown = %+v, want value 250 normalized to 0.75", c)
+	}
+
+	sum := 0.0
+	for _, c := range breakdown {
+		sum += c.Contribution
+	}
+	if math.Abs(sum-score) > 1e-9 || nodes["a"].Score != score {
+		t.Errorf("contributions sum to %v, entry score %v, calculated %v", sum, nodes["a"].Score, score)
+	}
+}
+
+func TestMetricsFilterAndPagination(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	var entries []*NodeMetrics
+	for _, name := range []string{"e", "c", "a", "d", "b"} {
+		entries = append(entries, &NodeMetrics{NodeName: name, RTTp99: 50})
+	}
+	se.seedCache(cfg, entries...)
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+		total string
+	}{
+		{"", []string{"a", "b", "c", "d", "e"}, ""},
+		{"?node=b,d,missing,b", []string{"b", "d"}, "2"},
+		{"?limit=2", []string{"a", "b"}, "5"},
+		{"?limit=2&offset=2", []string{"c", "d"}, "5"},
+		{"?offset=4", []string{"e"}, "5"},
+		{"?offset=9", nil, "5"},
+		{"?node=a,c,e&limit=1&offset=1", []string{"c"}, "3"},
+	} {
+		nodes, rec := getMetrics(t, se, tc.query)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: status %d", tc.query, rec.Code)
+			continue
+		}
+		var got []string
+		for name := range nodes {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: nodes %v, want %v", tc.query, got, tc.want)
+		}
+		if total := rec.Header().Get("X-Total-Count"); total != tc.total {
+			t.Errorf("%q: X-Total-Count %q, want %q", tc.query, total, tc.total)
+		}
+	}
+
+	for _, query := range []string{"?limit=-1", "?offset=x"} {
+		if _, rec := getMetrics(t, se, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestWarmupRampsTowardComputedScore(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.WarmupObservations = 4 })
+	se := newTestExtender(t, cfg, nil)
+
+	prevRatio, prevScore := -1.0, cfg.NeutralScore
+	for observations := 0; observations <= 6; observations++ {
+		ratio := se.warmupRatio(cfg, observations)
+		if ratio < prevRatio || ratio > 1 {
+			t.Errorf("%d observations: ratio %v after %v", observations, ratio, prevRatio)
+		}
+		if observations >= cfg.WarmupObservations && ratio != 1 {
+			t.Errorf("%d observations: ratio %v, want fully trusted", observations, ratio)
+		}
+		if observations < cfg.WarmupObservations && ratio >= 1 {
+			t.Errorf("%d observations: trusted before warmup ended", observations)
+		}
+		prevRatio = ratio
+
+		// A good node climbs from neutral toward its full score
+		if observations == 0 {
+			continue
+		}
+		se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5, Observations: observations})
+		score := se.calculateNodeScore(cfg, "a", scoreOptions{})
+		if observations <= cfg.WarmupObservations && score <= prevScore {
+			t.Errorf("%d observations: score %v did not rise from %v", observations, score, prevScore)
+		}
+		if observations > cfg.WarmupObservations && score != prevScore {
+			t.Errorf("%d observations: score %v moved after warmup from %v", observations, score, prevScore)
+		}
+		prevScore = score
+	}
+	if se.warmupRatio(cfg, 0) != 0 {
+		t.Errorf("unobserved node trusted %v", se.warmupRatio(cfg, 0))
+	}
+}
+
+func TestPrioritizeNodeNamesMode(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "good", RTTp99: 5},
+		&NodeMetrics{NodeName: "bad", RTTp99: 900},
+	)
+
+	// NodeCacheCapable: names only, no Nodes list
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), "good", "bad", "unknown")))
+	if len(scores) != 3 {
+		t.Fatalf("scores %v, want one per node name", scores)
+	}
+	if scores["good"] <= scores["bad"] {
+		t.Errorf("NodeNames scores don't follow metrics: %v", scores)
+	}
+	if scores["unknown"] != int64(cfg.NeutralScore) {
+		t.Errorf("unknown node scored %d, want neutral", scores["unknown"])
+	}
+
+	// The same names sent as full node objects rank identically
+	nodes := &v1core.NodeList{}
+	for _, name := range []string{"good", "bad", "unknown"} {
+		nodes.Items = append(nodes.Items, v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	body, _ := json.Marshal(extenderv1.ExtenderArgs{Pod: testPod("web", nil), Nodes: nodes})
+	for host, score := range scoresByHost(callPrioritize(t, se, body)) {
+		if score != scores[host] {
+			t.Errorf("%s scored %d from Nodes, %d from NodeNames", host, score, scores[host])
+		}
+	}
+}
+
+// getVerify fetches /verify for node.
+func getVerify(t *testing.T, se *SchedulerExtender, node string) verifyResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	se.verifyHandler(rec, httptest.NewRequest(http.MethodGet, "/verify?node="+node, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/verify status %d: %s", rec.Code, rec.Body)
+	}
+	var resp verifyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding /verify: %v", err)
+	}
+	return resp
+}
+
+func TestVerifyReturnsLiveAndCached(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 20, "b": 30}})
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5, Timestamp: time.Now().Add(-90 * time.Second).Unix()})
+
+	resp := getVerify(t, se, "a")
+	if resp.Node != "a" || resp.Live == nil || resp.Cached == nil || resp.CachedAgeSec == nil {
+		t.Fatalf("/verify = %+v, want live, cached and age", resp)
+	}
+	if resp.Live.RTTp99 != 20 || resp.Cached.RTTp99 != 5 {
+		t.Errorf("live RTT %v, cached RTT %v; want 20 and 5", resp.Live.RTTp99, resp.Cached.RTTp99)
+	}
+	if *resp.CachedAgeSec < 89 || *resp.CachedAgeSec > 120 {
+		t.Errorf("cached age %vs, want about 90s", *resp.CachedAgeSec)
+	}
+
+	// Verifying reads Prometheus but leaves the cache alone
+	if got := se.metricsCache["a"].RTTp99; got != 5 {
+		t.Errorf("cache RTT changed to %v", got)
+	}
+	if _, ok := se.metricsCache["b"]; ok {
+		t.Error("verify added another node to the cache")
+	}
+
+	if resp := getVerify(t, se, "b"); resp.Live == nil || resp.Cached != nil || resp.CachedAgeSec != nil {
+		t.Errorf("uncached node: %+v, want live values only", resp)
+	}
+}
+
+func TestQueryRateLimitPacesRefresh(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	prom := fakePrometheus(t, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 10}})
+	recorder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		prom.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer recorder.Close()
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.MaxQueriesPerSecond = 20 })
+	se := newTestExtender(t, cfg, nil)
+	client, _ := api.NewClient(api.Config{Address: recorder.URL})
+	se.promClient = v1.NewAPI(client)
+	se.queryLimiter = rate.NewLimiter(queryRateLimit(cfg), 1)
+
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+	if len(times) < 3 {
+		t.Fatalf("only %d queries issued", len(times))
+	}
+	// 20 queries per second with a burst of one: 50ms apart, with a little
+	// slack for timer granularity
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("query %d came %v after the previous one, want about 50ms", i, gap)
+		}
+	}
+}
+
+func TestRefreshFloor(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.CacheTTL = 0
+		cfg.MinRefreshInterval = 5
+	})
+	se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 10}})
+	clock := newFakeClock()
+	se.clock = clock
+
+	// A zero TTL expires the cache on every call; hammer refresh for 10s
+	for i := 0; i < 100; i++ {
+		if err := se.refresh(context.Background()); err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+		clock.advance(100 * time.Millisecond)
+	}
+	// Refreshes at 0s and 5s, and none in between
+	if se.generation != 2 {
+		t.Errorf("%d refreshes in 10s with a 5s floor, want 2", se.generation)
+	}
+
+	if interval := se.refreshInterval(cfg); interval != 5*time.Second {
+		t.Errorf("refresh loop interval %v, want the 5s floor", interval)
+	}
+}
+
+func TestCanaryScoreCapped(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.CanaryScoreCap = 30 })
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "canary", RTTp99: 1},
+		&NodeMetrics{NodeName: "regular", RTTp99: 1},
+		&NodeMetrics{NodeName: "poor-canary", RTTp99: 990, RetransRate: 99, DropRate: 990, RunqlatP95: 99, CPUUtil: 99},
+	)
+	prioritize := func(canaries ...string) map[string]int64 {
+		t.Helper()
+		nodes := &v1core.NodeList{}
+		for _, name := range []string{"canary", "regular", "poor-canary"} {
+			node := v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+			for _, canary := range canaries {
+				if canary == name {
+					node.Annotations[canaryAnnotation] = "true"
+				}
+			}
+			nodes.Items = append(nodes.Items, node)
+		}
+		body, _ := json.Marshal(extenderv1.ExtenderArgs{Pod: testPod("web", nil), Nodes: nodes})
+		return scoresByHost(callPrioritize(t, se, body))
+	}
+
+	scores := prioritize("canary", "poor-canary")
+	if scores["canary"] != 30 {
+		t.Errorf("canary with excellent metrics scored %d, want the cap 30", scores["canary"])
+	}
+	if scores["regular"] <= 30 {
+		t.Errorf("identical regular node scored %d, want it uncapped", scores["regular"])
+	}
+	if scores["poor-canary"] >= 30 {
+		t.Errorf("poor canary scored %d, want its own lower score", scores["poor-canary"])
+	}
+
+	// Clearing the annotation restores the full score
+	if scores := prioritize(); scores["canary"] != scores["regular"] {
+		t.Errorf("cleared canary scored %d, regular node %d", scores["canary"], scores["regular"])
+	}
+}
+
+func TestShutdownCancelsRefresh(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		// Hang like an overloaded Prometheus until the client gives up
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer prom.Close()
+	defer close(release)
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.QueryTimeoutSeconds = 30 })
+	se := newTestExtender(t, cfg, nil)
+	client, _ := api.NewClient(api.Config{Address: prom.URL})
+	se.promClient = v1.NewAPI(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		se.refreshLoop(ctx)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh never queried Prometheus")
+	}
+	shutdown := time.Now()
+	cancel()
+	select {
+	case <-done:
+		if elapsed := time.Since(shutdown); elapsed > time.Second {
+			t.Errorf("refresh took %v to stop after shutdown", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh loop still running 5s after shutdown")
+	}
+}
+
+func TestScoreBoundLabels(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "gpu", RTTp99: 1},
+		&NodeMetrics{NodeName: "generic", RTTp99: 1},
+		&NodeMetrics{NodeName: "reserved", RTTp99: 990, RetransRate: 99, DropRate: 990, RunqlatP95: 99, CPUUtil: 99},
+		&NodeMetrics{NodeName: "mislabelled", RTTp99: 1},
+	)
+	labels := map[string]map[string]string{
+		"gpu":         {maxScoreLabel: "70"},
+		"generic":     {},
+		"reserved":    {minScoreLabel: "40"},
+		"mislabelled": {maxScoreLabel: "lots"},
+	}
+	nodes := &v1core.NodeList{}
+	for _, name := range []string{"gpu", "generic", "reserved", "mislabelled"} {
+		nodes.Items = append(nodes.Items, v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels[name]}})
+	}
+	body, _ := json.Marshal(extenderv1.ExtenderArgs{Pod: testPod("web", nil), Nodes: nodes})
+	scores := scoresByHost(callPrioritize(t, se, body))
+
+	if scores["gpu"] != 70 {
+		t.Errorf("GPU node with excellent metrics scored %d, want its max-score 70", scores["gpu"])
+	}
+	if scores["generic"] <= 70 {
+		t.Errorf("identical unlabelled node scored %d, want it uncapped", scores["generic"])
+	}
+	if scores["reserved"] != 40 {
+		t.Errorf("poor node scored %d, want its min-score 40", scores["reserved"])
+	}
+	if scores["mislabelled"] != scores["generic"] {
+		t.Errorf("invalid bound label changed the score to %d", scores["mislabelled"])
+	}
+}
+
+func TestEmptyCandidateSetRejected(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5})
+
+	for _, body := range []string{
+		`{"pod":{"metadata":{"name":"web"}},"nodenames":[]}`,
+		`{"pod":{"metadata":{"name":"web"}},"nodes":{"items":[]}}`,
+		`{"pod":{"metadata":{"name":"web"}}}`,
+	} {
+		for path, handler := range map[string]http.HandlerFunc{"/prioritize": se.prioritize, "/filter": se.filter} {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("%s %s: status %d, want 400", path, body, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), "no candidate nodes") {
+				t.Errorf("%s %s: unhelpful error %q", path, body, rec.Body)
+			}
+		}
+	}
+}
+
+func TestVerboseHealth(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	verbose := func() healthResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		se.healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health?verbose=true", nil))
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("verbose health Content-Type %q", ct)
+		}
+		var resp healthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decoding verbose health: %v: %s", err, rec.Body)
+		}
+		return resp
+	}
+
+	resp := verbose()
+	if resp.Status != "OK" || resp.Version != version || resp.ConfigVersion == "" || resp.ConfigVersion != cfg.version {
+		t.Errorf("verbose health = %+v", resp)
+	}
+	if resp.LastRefresh != nil {
+		t.Errorf("last refresh %v before any refresh", resp.LastRefresh)
+	}
+
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5})
+	if resp := verbose(); resp.LastRefresh == nil || !resp.LastRefresh.Equal(se.lastUpdate) {
+		t.Errorf("last refresh %v, want %v", resp.LastRefresh, se.lastUpdate)
+	}
+
+	if got := healthBody(se); got != "OK" {
+		t.Errorf("default health body %q, want plain OK", got)
+	}
+}
+
+func TestPreferredZoneWinsTies(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.ZoneBonus = 10 })
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "east-1", RTTp99: 500, CPUUtil: 60},
+		&NodeMetrics{NodeName: "west-1", RTTp99: 500, CPUUtil: 60},
+	)
+	nodes := &v1core.NodeList{Items: []v1core.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "east-1", Labels: map[string]string{v1core.LabelTopologyZone: "us-east-1a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "west-1", Labels: map[string]string{v1core.LabelTopologyZone: "us-west-2b"}}},
+	}}
+	prioritize := func(pod *v1core.Pod) map[string]int64 {
+		t.Helper()
+		body, _ := json.Marshal(extenderv1.ExtenderArgs{Pod: pod, Nodes: nodes})
+		return scoresByHost(callPrioritize(t, se, body))
+	}
+
+	for zone, winner := range map[string]string{"us-east-1a": "east-1", "us-west-2b": "west-1"} {
+		scores := prioritize(testPod("api", map[string]string{preferredZoneAnnotation: zone}))
+		loser := "east-1"
+		if winner == loser {
+			loser = "west-1"
+		}
+		if scores[winner] != scores[loser]+10 {
+			t.Errorf("preferring %s: %s scored %d, %s %d; want the zone bonus of 10", zone, winner, scores[winner], loser, scores[loser])
+		}
+	}
+
+	if scores := prioritize(testPod("batch", nil)); scores["east-1"] != scores["west-1"] {
+		t.Errorf("pod without a zone preference: %v", scores)
+	}
+}
+
+// deadlineAPI answers every instant query with no data and records how
+// long each query's context had left.
+type deadlineAPI struct {
+	v1.API
+	mu        sync.Mutex
+	remaining []time.Duration
+}
+
+func (a *deadlineAPI) Query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	deadline, ok := ctx.Deadline()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ok {
+		a.remaining = append(a.remaining, time.Until(deadline))
+	} else {
+		a.remaining = append(a.remaining, -1)
+	}
+	return model.Vector{}, nil, nil
+}
+
+func TestQueryTimeoutApplied(t *testing.T) {
+	for _, seconds := range []int{2, 30} {
+		cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.QueryTimeoutSeconds = seconds })
+		se := newTestExtender(t, cfg, nil)
+		promAPI := &deadlineAPI{}
+		se.promClient = promAPI
+		se.updateMetrics(context.Background(), cfg)
+
+		timeout := time.Duration(seconds) * time.Second
+		if len(promAPI.remaining) == 0 {
+			t.Fatal("no queries issued")
+		}
+		for _, remaining := range promAPI.remaining {
+			if remaining <= timeout-time.Second || remaining > timeout {
+				t.Errorf("timeout %v: query context had %v left", timeout, remaining)
+			}
+		}
+	}
+
+	cfg := defaultConfig()
+	cfg.QueryTimeoutSeconds = 0
+	if validateConfig(cfg) == nil {
+		t.Error("zero query timeout accepted")
+	}
+}
+
+func TestFilterOnTotalMetricLoss(t *testing.T) {
+	body := extenderArgs(testPod("web", nil), "a", "b")
+	filter := func(ignorable bool) extenderv1.ExtenderFilterResult {
+		cfg := testConfig(t, func(cfg *ExtenderConfig) {
+			cfg.Ignorable = ignorable
+			cfg.FilterThresholds = map[string]float64{"rtt_p99": 100}
+		})
+		// Prometheus answers, but with no series at all
+		return callFilter(t, newTestExtender(t, cfg, nil), body)
+	}
+
+	// An ignorable extender stays quiet and lets every node through
+	result := filter(true)
+	if result.Error != "" {
+		t.Errorf("ignorable: Error = %q, want none", result.Error)
+	}
+	if len(result.FailedNodes) != 0 {
+		t.Errorf("ignorable: failed nodes %v, want none", result.FailedNodes)
+	}
+	if result.NodeNames == nil || len(*result.NodeNames) != 2 {
+		t.Errorf("ignorable: passed nodes %v, want both", result.NodeNames)
+	}
+
+	// A non-ignorable one reports the loss without failing nodes itself
+	result = filter(false)
+	if result.Error == "" {
+		t.Error("non-ignorable: no Error on total metric loss")
+	}
+	if len(result.FailedNodes) != 0 {
+		t.Errorf("non-ignorable: failed nodes %v, want none", result.FailedNodes)
+	}
+}
+
+func TestFailOnEmptyCache(t *testing.T) {
+	// With Prometheus down the cache stays empty and expired
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	client, err := api.NewClient(api.Config{Address: down.URL})
+	if err != nil {
+		t.Fatalf("api.NewClient: %v", err)
+	}
+	prioritize := func(cfg *ExtenderConfig) *httptest.ResponseRecorder {
+		se := newTestExtender(t, cfg, nil)
+		se.promClient = v1.NewAPI(client)
+		rec := httptest.NewRecorder()
+		body := extenderArgs(testPod("web", nil), "a", "b")
+		se.prioritize(rec, httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(body)))
+		return rec
+	}
+
+	// By default every node scores neutral
+	cfg := testConfig(t, nil)
+	rec := prioritize(cfg)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("default: status %d: %s", rec.Code, rec.Body)
+	}
+	var result extenderv1.HostPriorityList
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decoding prioritize response: %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("default: %d nodes scored, want 2", len(result))
+	}
+	for node, score := range scoresByHost(result) {
+		if score != int64(cfg.NeutralScore) {
+			t.Errorf("default: node %s scored %d, want neutral %v", node, score, cfg.NeutralScore)
+		}
+	}
+
+	rec = prioritize(testConfig(t, func(cfg *ExtenderConfig) { cfg.FailOnEmptyCache = true }))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("FailOnEmptyCache: status %d, want 503", rec.Code)
+	}
+}
+
+func TestWarmupPeriodScoresNeutral(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.WarmupPeriod = 60 })
+	se := newTestExtender(t, cfg, nil)
+	clock := newFakeClock()
+	se.clock = clock
+	se.startedAt = clock.Now()
+	se.seedCache(cfg, &NodeMetrics{NodeName: "good", RTTp99: 5}, &NodeMetrics{NodeName: "bad", RTTp99: 900, DropRate: 500})
+
+	clock.advance(59 * time.Second)
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("early", nil), "good", "bad")))
+	if len(scores) != 2 {
+		t.Fatalf("during warmup: scored %v, want both nodes", scores)
+	}
+	for node, score := range scores {
+		if score != int64(cfg.NeutralScore) {
+			t.Errorf("during warmup: %s scored %d, want neutral %v", node, score, cfg.NeutralScore)
+		}
+	}
+
+	clock.advance(time.Second)
+	scores = scoresByHost(callPrioritize(t, se, extenderArgs(testPod("late", nil), "good", "bad")))
+	if scores["good"] <= scores["bad"] {
+		t.Errorf("after warmup: good scored %d, bad %d", scores["good"], scores["bad"])
+	}
+}
+
+func TestNodePassesFilterButRanksLast(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.FilterThresholds = map[string]float64{"rtt_p99": 800}
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "fast", RTTp99: 20},
+		&NodeMetrics{NodeName: "middling", RTTp99: 200},
+		&NodeMetrics{NodeName: "laggy", RTTp99: 600},
+	)
+	body := extenderArgs(testPod("web", nil), "fast", "middling", "laggy")
+
+	if result := callFilter(t, se, body); len(result.FailedNodes) != 0 {
+		t.Errorf("failed nodes %v, want all under the 800ms threshold to pass", result.FailedNodes)
+	}
+	scores := scoresByHost(callPrioritize(t, se, body))
+	for _, node := range []string{"fast", "middling"} {
+		if scores["laggy"] >= scores[node] {
+			t.Errorf("laggy node scored %d, not below %s's %d", scores["laggy"], node, scores[node])
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "fmt"
+
+// foldNodeGroups hands each group's samples to its member nodes, for
+// every metric a member has no samples of its own for, and drops the
+// group itself so it isn't scored as a node.
+func foldNodeGroups(groups map[string][]string, metricsData map[string]map[string][]float64) {
+	for _, values := range metricsData {
+		for group, members := range groups {
+			samples, ok := values[group]
+			if !ok {
+				continue
+			}
+			for _, member := range members {
+				if _, own := values[member]; !own {
+					values[member] = samples
+				}
+			}
+			delete(values, group)
+		}
+	}
+}
+
+// validateNodeGroups rejects nodes in more than one group, which would
+// inherit from whichever group came first.
+func validateNodeGroups(groups map[string][]string) error {
+	memberOf := make(map[string]string)
+	for group, members := range groups {
+		for _, member := range members {
+			if other, ok := memberOf[member]; ok && other != group {
+				return fmt.Errorf("node %s is in node groups %s and %s", member, other, group)
+			}
+			memberOf[member] = group
+		}
+	}
+	return nil
+}
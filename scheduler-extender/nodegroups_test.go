@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRackRTTFoldedIntoMembers(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.NodeGroups = map[string][]string{"rack-1": {"edge-a", "edge-b", "edge-c"}}
+	})
+	se := newTestExtender(t, cfg, promSeries{
+		"ebpf_rtt_p99_milliseconds": {"rack-1": 120, "edge-c": 30},
+		"ebpf_drop_rate":            {"edge-a": 1, "edge-b": 2, "edge-c": 3},
+	})
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	for node, want := range map[string]float64{"edge-a": 120, "edge-b": 120, "edge-c": 30} {
+		m, ok := se.metricsCache[node]
+		if !ok {
+			t.Errorf("%s missing from the cache", node)
+			continue
+		}
+		if m.RTTp99 != want {
+			t.Errorf("%s RTT %v, want %v", node, m.RTTp99, want)
+		}
+	}
+	if _, ok := se.metricsCache["rack-1"]; ok {
+		t.Error("rack-1 cached as a node")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// saveCacheFile writes the metrics cache to CacheFile. The file is written
+// to a temporary path and renamed so a crash never leaves a truncated cache.
+// cache may already be published, so it is encoded under se.mu: scoring
+// updates the entries while holding the write lock.
+func (se *SchedulerExtender) saveCacheFile(cfg *ExtenderConfig, cache map[string]*NodeMetrics) error {
+	se.mu.RLock()
+	data, err := json.Marshal(cache)
+	se.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode cache: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cfg.CacheFile), ".metrics-cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), cfg.CacheFile)
+}
+
+// loadCacheFile restores the metrics cache written by a previous run,
+// ignoring files older than cacheFileMaxAge.
+func (se *SchedulerExtender) loadCacheFile(cfg *ExtenderConfig) error {
+	info, err := os.Stat(cfg.CacheFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// ModTime carries no monotonic reading, so this is where a wall-clock
+	// step shows up; since applies the configured skew policy
+	age := se.since(cfg, info.ModTime())
+	if maxAge := cacheFileMaxAge(cfg); maxAge > 0 && age > maxAge {
+		return fmt.Errorf("cache file exceeds max age of %v", maxAge)
+	}
+
+	data, err := os.ReadFile(cfg.CacheFile)
+	if err != nil {
+		return err
+	}
+
+	cache := make(map[string]*NodeMetrics)
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return fmt.Errorf("failed to decode cache: %w", err)
+	}
+
+	precomputeScores(cfg, cache)
+	se.metricsCache = cache
+	// Treat the cache as fetched when it was written so the TTL still applies
+	se.lastUpdate = info.ModTime()
+	se.generation++
+	// The restored entries score like freshly fetched ones, so don't hold
+	// readiness back until the TTL runs out and the first refresh lands
+	se.ready.Store(true)
+
+	if cfg.Debug {
+		log.Printf("Loaded %d cached node metrics from %s", len(cache), cfg.CacheFile)
+	}
+
+	return nil
+}
+
+// cacheFileMaxAge is the oldest cache file worth restoring. Entries past
+// MaxMetricAge would only score neutral, so it caps CacheMaxAge; 0 means
+// no limit.
+func cacheFileMaxAge(cfg *ExtenderConfig) time.Duration {
+	maxAge := cfg.CacheMaxAge
+	if cfg.MaxMetricAge > 0 && (maxAge <= 0 || cfg.MaxMetricAge < maxAge) {
+		maxAge = cfg.MaxMetricAge
+	}
+	return time.Duration(maxAge) * time.Second
+}
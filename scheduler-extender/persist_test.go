@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheFileWarmsNewExtender(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "metrics-cache.json")
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.CacheFile = cacheFile })
+	previous := newTestExtender(t, cfg, nil)
+	previous.seedCache(cfg,
+		&NodeMetrics{NodeName: "fast", RTTp99: 5, CPUUtil: 10},
+		&NodeMetrics{NodeName: "slow", RTTp99: 900, CPUUtil: 95, DropRate: 4},
+	)
+	if err := previous.saveCacheFile(cfg, previous.metricsCache); err != nil {
+		t.Fatalf("saveCacheFile: %v", err)
+	}
+
+	var queries atomic.Int32
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(prom.Close)
+
+	t.Setenv("PROMETHEUS_URL", prom.URL)
+	t.Setenv("CACHE_FILE", cacheFile)
+	t.Setenv("CACHE_TTL", "3600")
+	t.Setenv("DEBUG", "false")
+	se, err := NewSchedulerExtender()
+	if err != nil {
+		t.Fatalf("NewSchedulerExtender: %v", err)
+	}
+
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), "fast", "slow")))
+	if scores["fast"] <= scores["slow"] {
+		t.Errorf("cached metrics not used: fast=%d slow=%d", scores["fast"], scores["slow"])
+	}
+	if n := queries.Load(); n != 0 {
+		t.Errorf("%d queries issued before the cached metrics were used", n)
+	}
+}
+
+func TestCacheFileIgnoredPastMaxAge(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "metrics-cache.json")
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.CacheFile = cacheFile
+		cfg.CacheMaxAge = 60
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5})
+	if err := se.saveCacheFile(cfg, se.metricsCache); err != nil {
+		t.Fatalf("saveCacheFile: %v", err)
+	}
+
+	restored := newTestExtender(t, cfg, nil)
+	restored.clock = &fakeClock{now: time.Now().Add(2 * time.Minute)}
+	if err := restored.loadCacheFile(cfg); err == nil {
+		t.Error("cache file older than CacheMaxAge was loaded")
+	}
+	if len(restored.metricsCache) != 0 {
+		t.Errorf("restored %d entries from an expired file", len(restored.metricsCache))
+	}
+}
+
+// Run under -race: the cache file is written after the new map is
+// published, while prioritize stores scores on the same entries.
+func TestSaveCacheFileConcurrentWithScoring(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.CacheFile = filepath.Join(t.TempDir(), "metrics-cache.json")
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "a", RTTp99: 10, CPUUtil: 30},
+		&NodeMetrics{NodeName: "b", RTTp99: 200, CPUUtil: 80},
+	)
+	se.mu.RLock()
+	published := se.metricsCache
+	se.mu.RUnlock()
+	body := extenderArgs(testPod("web", nil), "a", "b")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			rec := httptest.NewRecorder()
+			se.prioritize(rec, httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Errorf("prioritize status %d: %s", rec.Code, rec.Body)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := se.saveCacheFile(cfg, published); err != nil {
+			t.Errorf("saveCacheFile: %v", err)
+			break
+		}
+	}
+	wg.Wait()
+}
+
+// A file younger than CacheMaxAge but older than MaxMetricAge holds
+// nothing but neutral scores, so it must not be restored.
+func TestCacheFileCappedAtMaxMetricAge(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "metrics-cache.json")
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.CacheFile = cacheFile
+		cfg.CacheMaxAge = 300
+		cfg.MaxMetricAge = 60
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5})
+	if err := se.saveCacheFile(cfg, se.metricsCache); err != nil {
+		t.Fatalf("saveCacheFile: %v", err)
+	}
+
+	stale := newTestExtender(t, cfg, nil)
+	stale.clock = &fakeClock{now: time.Now().Add(90 * time.Second)}
+	if err := stale.loadCacheFile(cfg); err == nil {
+		t.Error("cache file older than MaxMetricAge was loaded")
+	}
+	if len(stale.metricsCache) != 0 || stale.ready.Load() {
+		t.Errorf("stale file restored %d entries, ready=%v", len(stale.metricsCache), stale.ready.Load())
+	}
+
+	fresh := newTestExtender(t, cfg, nil)
+	fresh.clock = &fakeClock{now: time.Now().Add(30 * time.Second)}
+	if err := fresh.loadCacheFile(cfg); err != nil {
+		t.Fatalf("loadCacheFile: %v", err)
+	}
+	if len(fresh.metricsCache) != 1 || !fresh.ready.Load() {
+		t.Errorf("fresh file restored %d entries, ready=%v", len(fresh.metricsCache), fresh.ready.Load())
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// placementGate is the readiness gate condition type a pod lists to have
+// its placement quality annotated. A controller sets the condition from
+// the annotations below.
+const placementGate = "ebpf-scheduler/placement-quality"
+
+// Annotations written on gated pods once they are bound to a node.
+const (
+	placementQualityAnnotation = "ebpf-scheduler/placement-quality"
+	placementScoreAnnotation   = "ebpf-scheduler/placement-score"
+)
+
+// Values of placementQualityAnnotation.
+const (
+	placementMet   = "met"
+	placementBelow = "below"
+)
+
+// annotatePlacements watches pods and, once a pod with the placement
+// readiness gate is bound, records whether its node scored at least
+// PlacementQualityBar. It runs until ctx is cancelled.
+func (se *SchedulerExtender) annotatePlacements(ctx context.Context) {
+	factory := informers.NewSharedInformerFactory(se.kubeClient, 0)
+	podInformer := factory.Core().V1().Pods().Informer()
+
+	handle := func(obj interface{}) {
+		pod, ok := obj.(*v1core.Pod)
+		if !ok || !needsPlacementAnnotation(pod) {
+			return
+		}
+		if err := se.annotatePlacement(ctx, pod); err != nil {
+			log.Printf("Failed to annotate placement of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    handle,
+		UpdateFunc: func(_, obj interface{}) { handle(obj) },
+	})
+
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
+
+// needsPlacementAnnotation reports whether pod is bound, carries the
+// placement readiness gate and hasn't been annotated yet.
+func needsPlacementAnnotation(pod *v1core.Pod) bool {
+	if pod.Spec.NodeName == "" {
+		return false
+	}
+	if _, done := pod.Annotations[placementQualityAnnotation]; done {
+		return false
+	}
+	for _, gate := range pod.Spec.ReadinessGates {
+		if string(gate.ConditionType) == placementGate {
+			return true
+		}
+	}
+	return false
+}
+
+// annotatePlacement scores the pod's node as prioritize would have and
+// patches the result onto the pod.
+func (se *SchedulerExtender) annotatePlacement(ctx context.Context, pod *v1core.Pod) error {
+	cfg := se.config.Load()
+	nodeName := pod.Spec.NodeName
+
+	nodes := make(map[string]*v1core.Node)
+	if se.nodeLister != nil {
+		if node, err := se.nodeLister.Get(nodeName); err == nil {
+			nodes[nodeName] = node
+		}
+	}
+	// Score as rankNodes does, but leave the node's history alone: an
+	// annotation is not a new observation of the node
+	opts := se.podScoreOptions(cfg, pod, []string{nodeName}, nodes)
+	score := se.adjustNodeScore(cfg, nodeName, se.computeNodeScore(cfg, nodeName, opts), opts)
+
+	quality := placementBelow
+	if score >= cfg.PlacementQualityBar {
+		quality = placementMet
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				placementQualityAnnotation: quality,
+				placementScoreAnnotation:   strconv.FormatFloat(score, 'f', 0, 64),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = se.kubeClient.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err == nil && cfg.Debug {
+		log.Printf("Pod %s/%s placed on %s scored %.2f (%s)", pod.Namespace, pod.Name, nodeName, score, quality)
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// placedPod is a pod bound to nodeName, optionally with the placement gate.
+func placedPod(name, nodeName string, gated bool) *v1core.Pod {
+	pod := testPod(name, nil)
+	pod.Spec.NodeName = nodeName
+	if gated {
+		pod.Spec.ReadinessGates = []v1core.PodReadinessGate{{ConditionType: placementGate}}
+	}
+	return pod
+}
+
+func TestPlacementAnnotated(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.PlacementQualityBar = 60 })
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "good", RTTp99: 5},
+		&NodeMetrics{NodeName: "bad", RTTp99: 990, RetransRate: 99, DropRate: 990, RunqlatP95: 99, CPUUtil: 99},
+	)
+	client := fake.NewSimpleClientset(
+		placedPod("on-good", "good", true),
+		placedPod("on-bad", "bad", true),
+		placedPod("ungated", "good", false),
+	)
+	se.kubeClient = client
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		se.annotatePlacements(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	annotations := func(name string) map[string]string {
+		pod, err := client.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("getting pod %s: %v", name, err)
+		}
+		return pod.Annotations
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for annotations("on-good")[placementQualityAnnotation] == "" || annotations("on-bad")[placementQualityAnnotation] == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("gated pods never annotated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := annotations("on-good"); got[placementQualityAnnotation] != placementMet || got[placementScoreAnnotation] == "" {
+		t.Errorf("pod on the good node annotated %v", got)
+	}
+	if got := annotations("on-bad")[placementQualityAnnotation]; got != placementBelow {
+		t.Errorf("pod on the bad node annotated %q, want %q", got, placementBelow)
+	}
+	if got := annotations("ungated"); len(got) != 0 {
+		t.Errorf("pod without the readiness gate annotated %v", got)
+	}
+}
+
+// The annotation must agree with prioritize, zone bonus included, and
+// must not count as an observation in the node's history.
+func TestPlacementScoreMatchesRanking(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.ZoneBonus = 10 })
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 500, CPUUtil: 50})
+
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "a",
+		Labels: map[string]string{v1core.LabelTopologyZone: "zone-1"},
+	}}
+	se.nodeLister = fakeNodeLister(t, node)
+
+	pod := placedPod("web", "a", true)
+	pod.Annotations = map[string]string{preferredZoneAnnotation: "zone-1"}
+	client := fake.NewSimpleClientset(pod)
+	se.kubeClient = client
+
+	if err := se.annotatePlacement(context.Background(), pod); err != nil {
+		t.Fatalf("annotatePlacement: %v", err)
+	}
+	if got := se.history.get("a"); len(got) != 0 {
+		t.Errorf("annotating recorded %d history entries", len(got))
+	}
+
+	patched, err := client.CoreV1().Pods("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting pod: %v", err)
+	}
+	annotated, err := strconv.ParseFloat(patched.Annotations[placementScoreAnnotation], 64)
+	if err != nil {
+		t.Fatalf("parsing score annotation: %v", err)
+	}
+	ranked := se.rankNodes(cfg, pod, []string{"a"}, map[string]*v1core.Node{"a": node})
+	if diff := annotated - float64(ranked[0].Score); diff < 0 || diff > 1 {
+		t.Errorf("annotated score %.0f, prioritize scored %d", annotated, ranked[0].Score)
+	}
+}
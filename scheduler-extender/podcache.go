@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// maxPodScoreCacheEntries bounds the ranking cache; it is reset when full.
+const maxPodScoreCacheEntries = 1024
+
+// podScoreCache reuses the ranking computed for a pod across pods scored
+// the same way: the same weight profile and CPU request, e.g. replicas of
+// one Deployment or workloads sharing a profile. Entries are only valid for
+// the metrics cache generation they were computed against.
+type podScoreCache struct {
+	mu         sync.Mutex
+	generation uint64
+	entries    map[string]extenderv1.HostPriorityList
+}
+
+func newPodScoreCache() *podScoreCache {
+	return &podScoreCache{entries: make(map[string]extenderv1.HostPriorityList)}
+}
+
+func (c *podScoreCache) get(key string, generation uint64) (extenderv1.HostPriorityList, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return nil, false
+	}
+	result, ok := c.entries[key]
+	return result, ok
+}
+
+func (c *podScoreCache) put(key string, generation uint64, result extenderv1.HostPriorityList) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation || len(c.entries) >= maxPodScoreCacheEntries {
+		c.entries = make(map[string]extenderv1.HostPriorityList)
+		c.generation = generation
+	}
+	c.entries[key] = result
+}
+
+// podScoreKey identifies a ranking by everything of the pod that scoring
+// reads: its weight profile and CPU request. The pod's preferred zone and
+// siblings show up in adjusted. Also part of the key are the candidate
+// nodes (in order, since the response follows it), the nodes whose state
+// adjusts their score, and the config version.
+func podScoreKey(opts scoreOptions, nodeNames, adjusted []string, configVersion string) string {
+	profile := ""
+	if opts.profile != nil {
+		profile = opts.profile.name
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%g\x00", profile, opts.cpuRequestCores)
+	h.Write([]byte(strings.Join(nodeNames, "\x00")))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(adjusted, "\x00")))
+	h.Write([]byte{0})
+	h.Write([]byte(configVersion))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// adjustedNodes describes the candidates whose Node object or siblings
+// adjust their score (canaries, disrupted nodes, score bounds, the
+// preferred zone, spreading), so a cordon, annotation, label change or
+// newly placed sibling invalidates cached rankings.
+func adjustedNodes(nodeNames []string, opts scoreOptions) []string {
+	var adjusted []string
+	for _, name := range nodeNames {
+		node := opts.nodes[name]
+		floor, ceiling, bounded := nodeScoreBounds(node)
+		canary := node != nil && node.Annotations[canaryAnnotation] == "true"
+		disrupted := nodeDisrupted(node)
+		zoned := nodeInZone(node, opts.preferredZone)
+		siblings := opts.siblings[name]
+		if bounded || canary || disrupted || zoned || siblings > 0 {
+			adjusted = append(adjusted, fmt.Sprintf("%s:%t:%t:%t:%d:%g:%g", name, canary, disrupted, zoned, siblings, floor, ceiling))
+		}
+	}
+	return adjusted
+}
+
+// configVersion hashes the configuration so cached rankings computed under
+// different settings are never reused.
+func configVersion(config *ExtenderConfig) string {
+	data, _ := json.Marshal(config)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
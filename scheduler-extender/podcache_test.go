@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// replica returns a Deployment replica requesting cpu.
+func replica(i int, cpu string) *v1core.Pod {
+	pod := testPod(fmt.Sprintf("web-%d", i), nil)
+	pod.Spec.Containers = []v1core.Container{{
+		Name: "web",
+		Resources: v1core.ResourceRequirements{
+			Requests: v1core.ResourceList{v1core.ResourceCPU: resource.MustParse(cpu)},
+		},
+	}}
+	return pod
+}
+
+func TestPodScoreCacheReusesRanking(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.PodScoreCache = true })
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "a", RTTp99: 5},
+		&NodeMetrics{NodeName: "b", RTTp99: 300, CPUUtil: 80},
+	)
+
+	first := scoresByHost(callPrioritize(t, se, extenderArgs(replica(0, "500m"), "a", "b")))
+	for i := 1; i < 20; i++ {
+		scores := scoresByHost(callPrioritize(t, se, extenderArgs(replica(i, "500m"), "a", "b")))
+		if scores["a"] != first["a"] || scores["b"] != first["b"] {
+			t.Fatalf("replica %d ranked %v, first replica %v", i, scores, first)
+		}
+	}
+	if n := se.scoreComputations.Load(); n != 1 {
+		t.Errorf("%d rankings computed for 20 identical pods, want 1", n)
+	}
+
+	// A different request or candidate set is a different ranking
+	callPrioritize(t, se, extenderArgs(replica(20, "2"), "a", "b"))
+	callPrioritize(t, se, extenderArgs(replica(21, "500m"), "b", "a"))
+	if n := se.scoreComputations.Load(); n != 3 {
+		t.Errorf("%d rankings computed, want 3 after a new request and node order", n)
+	}
+
+	// New metrics invalidate every cached ranking
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 900}, &NodeMetrics{NodeName: "b", RTTp99: 5})
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(replica(22, "500m"), "a", "b")))
+	if n := se.scoreComputations.Load(); n != 4 {
+		t.Errorf("%d rankings computed, want a recompute after a refresh", n)
+	}
+	if scores["a"] >= scores["b"] {
+		t.Errorf("stale ranking served after a refresh: %v", scores)
+	}
+}
+
+func TestPodScoreCacheSharedByProfile(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.PodScoreCache = true
+		cfg.Profiles = map[string]WeightProfile{
+			"latency": {ScoreWeights: ScoreWeights{RTTp99: 1}},
+			"batch":   {ScoreWeights: ScoreWeights{CPUUtil: 1}},
+		}
+	})
+	se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 5, "b": 300}})
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+	pod := func(name, profile string) *v1core.Pod {
+		return testPod(name, map[string]string{profileAnnotation: profile, "team": name})
+	}
+
+	first := callPrioritize(t, se, extenderArgs(pod("checkout", "latency"), "a", "b"))
+	second := callPrioritize(t, se, extenderArgs(pod("search", "latency"), "a", "b"))
+	if n := se.scoreComputations.Load(); n != 1 {
+		t.Errorf("%d rankings computed for two pods sharing a profile, want 1", n)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("second pod ranked %v, first %v", second, first)
+	}
+
+	callPrioritize(t, se, extenderArgs(pod("reports", "batch"), "a", "b"))
+	if n := se.scoreComputations.Load(); n != 2 {
+		t.Errorf("%d rankings computed, want a new one for another profile", n)
+	}
+
+	// A refresh swaps the cache and starts a new generation
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+	callPrioritize(t, se, extenderArgs(pod("checkout", "latency"), "a", "b"))
+	if n := se.scoreComputations.Load(); n != 3 {
+		t.Errorf("%d rankings computed, want a recompute after updateMetrics", n)
+	}
+}
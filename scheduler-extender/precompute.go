@@ -0,0 +1,95 @@
+package main
+
+// precomputedScore is a node's base-weight score, computed once per metrics
+// refresh instead of on every prioritize call. It is only valid for the
+// config it was computed under, so a reload falls back to the full path
+// until precomputeScores runs again.
+type precomputedScore struct {
+	config *ExtenderConfig
+	// raw is the scorer's output before clamping
+	raw        float64
+	components map[string]ScoreComponent
+	// smoothed is the EWMA of raw across refreshes under config
+	smoothed float64
+}
+
+// precomputeScores stores each node's base-weight score for cfg. Callers
+// must hold se.mu or own cache exclusively.
+func precomputeScores(cfg *ExtenderConfig, cache map[string]*NodeMetrics) {
+	for nodeName, metrics := range cache {
+		components := scoreComponents(cfg, nodeName, metrics, &cfg.Weights, 0)
+		raw := scorers[cfg.Scorer].Score(metrics, cfg, components)
+		metrics.precomputed = &precomputedScore{
+			config:     cfg,
+			raw:        raw,
+			components: components,
+			smoothed:   raw,
+		}
+	}
+}
+
+// smoothScores continues each node's score EWMA from previous. Nodes new
+// to the cache, or last scored under another config, start afresh.
+func smoothScores(cfg *ExtenderConfig, cache, previous map[string]*NodeMetrics) {
+	if cfg.ScoreEWMAAlpha >= 1 {
+		return
+	}
+	for nodeName, metrics := range cache {
+		prev, ok := previous[nodeName]
+		if !ok || prev.precomputed == nil || prev.precomputed.config != cfg {
+			continue
+		}
+		p := metrics.precomputed
+		p.smoothed = cfg.ScoreEWMAAlpha*p.raw + (1-cfg.ScoreEWMAAlpha)*prev.precomputed.smoothed
+	}
+}
+
+// smoothingOffset is how far smoothing moves the node's score. Scores
+// under a profile or with a CPU request shift by the same amount as the
+// base-weight score.
+func smoothingOffset(cfg *ExtenderConfig, metrics *NodeMetrics) float64 {
+	p := metrics.precomputed
+	if cfg.ScoreEWMAAlpha >= 1 || p == nil || p.config != cfg {
+		return 0
+	}
+	return p.smoothed - p.raw
+}
+
+// precomputeCachedScores refreshes the precomputed scores after a config
+// change.
+func (se *SchedulerExtender) precomputeCachedScores(cfg *ExtenderConfig) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	precomputeScores(cfg, se.metricsCache)
+}
+
+// precomputedNodeScore returns the node's raw score from its precomputed
+// value when that matches what the full path would compute: the base
+// weights under the live config. The pod's CPU request only shifts the
+// cpu_util term, which the weighted sum can apply linearly; other scorers
+// take the full path for pods with a request.
+func precomputedNodeScore(cfg *ExtenderConfig, metrics *NodeMetrics, opts scoreOptions) (float64, map[string]ScoreComponent, bool) {
+	p := metrics.precomputed
+	if p == nil || p.config != cfg || opts.profile != nil {
+		return 0, nil, false
+	}
+	cpu, ok := p.components["cpu_util"]
+	if !ok || cpu.Inverted || opts.cpuRequestCores <= 0 || cfg.CPURequestReference <= 0 {
+		return p.raw, p.components, true
+	}
+	if cfg.Scorer != scorerWeighted || cpu.Confidence < 1 {
+		return 0, nil, false
+	}
+	scaled := scaleCPUPenalty(cfg, cpu.Normalized, opts.cpuRequestCores)
+
+	// The shared components stay as precomputed; the breakdown gets a copy
+	// matching the adjusted score
+	components := make(map[string]ScoreComponent, len(p.components))
+	for name, c := range p.components {
+		components[name] = c
+	}
+	cpu.Normalized = scaled
+	cpu.Contribution = cpu.Weight * scaled * maxScore
+	components["cpu_util"] = cpu
+	return p.raw + cpu.Contribution - p.components["cpu_util"].Contribution, components, true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestPrecomputedMatchesFullPath(t *testing.T) {
+	cfg := testConfig(t, nil)
+	m := &NodeMetrics{NodeName: "a", RTTp99: 120, CPUUtil: 70, DropRate: 1, RunqlatP95: 4}
+	precomputeScores(cfg, map[string]*NodeMetrics{"a": m})
+
+	for _, cpu := range []float64{0, 0.5, 2, 8} {
+		fast, breakdown, ok := precomputedNodeScore(cfg, m, scoreOptions{cpuRequestCores: cpu})
+		if !ok {
+			t.Fatalf("cpu %v: precomputed score unavailable", cpu)
+		}
+		components := scoreComponents(cfg, "a", m, &cfg.Weights, cpu)
+		full := scorers[cfg.Scorer].Score(m, cfg, components)
+		if math.Abs(fast-full) > 1e-9 {
+			t.Errorf("cpu %v: precomputed %v, full path %v", cpu, fast, full)
+		}
+
+		sum := 0.0
+		for name, c := range breakdown {
+			sum += c.Contribution
+			if want := components[name]; math.Abs(c.Normalized-want.Normalized) > 1e-9 {
+				t.Errorf("cpu %v: %s normalized %v in breakdown, %v on the full path", cpu, name, c.Normalized, want.Normalized)
+			}
+		}
+		if math.Abs(sum-fast) > 1e-9 {
+			t.Errorf("cpu %v: breakdown sums to %v, score is %v", cpu, sum, fast)
+		}
+	}
+
+	// Adjusting for one pod must not leak into the next
+	if got := m.precomputed.components["cpu_util"].Normalized; got != scoreComponents(cfg, "a", m, &cfg.Weights, 0)["cpu_util"].Normalized {
+		t.Errorf("precomputed cpu_util component was modified to %v", got)
+	}
+}
+
+func TestPrecomputedSkippedForProfiles(t *testing.T) {
+	cfg := testConfig(t, nil)
+	m := &NodeMetrics{NodeName: "a", RTTp99: 120}
+	precomputeScores(cfg, map[string]*NodeMetrics{"a": m})
+
+	if _, _, ok := precomputedNodeScore(cfg, m, scoreOptions{profile: &WeightProfile{}}); ok {
+		t.Error("precomputed score used under a profile")
+	}
+	if _, _, ok := precomputedNodeScore(testConfig(t, nil), m, scoreOptions{}); ok {
+		t.Error("precomputed score used under another config")
+	}
+}
+
+// BenchmarkPrioritize compares scoring from precomputed scores with the
+// full path taken after they are invalidated.
+func BenchmarkPrioritize(b *testing.B) {
+	for _, nodes := range []int{100, 1000} {
+		for _, precomputed := range []bool{true, false} {
+			b.Run(fmt.Sprintf("nodes=%d/precomputed=%v", nodes, precomputed), func(b *testing.B) {
+				cfg := testConfig(b, nil)
+				se := newTestExtender(b, cfg, nil)
+
+				entries := make([]*NodeMetrics, nodes)
+				names := make([]string, nodes)
+				for i := range entries {
+					names[i] = fmt.Sprintf("node-%04d", i)
+					entries[i] = &NodeMetrics{
+						NodeName: names[i],
+						RTTp99:   float64(i % 500),
+						CPUUtil:  float64(i % 100),
+						DropRate: float64(i%7) / 2,
+					}
+				}
+				se.seedCache(cfg, entries...)
+				if !precomputed {
+					// As after a reload, before the next refresh recomputes them
+					for _, m := range entries {
+						m.precomputed = nil
+					}
+				}
+
+				pod := testPod("web", nil)
+				pod.Spec.Containers = []v1core.Container{{
+					Name: "web",
+					Resources: v1core.ResourceRequirements{Requests: v1core.ResourceList{
+						v1core.ResourceCPU: resource.MustParse("500m"),
+					}},
+				}}
+				body := extenderArgs(pod, names...)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					callPrioritize(b, se, body)
+				}
+			})
+		}
+	}
+}
+
+func TestEWMADampensSpike(t *testing.T) {
+	// drop is how far a one-refresh RTT spike pulls the node's score down
+	drop := func(alpha float64) int64 {
+		cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.ScoreEWMAAlpha = alpha })
+		rtt := map[string]float64{}
+		se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": rtt})
+		var scores []int64
+		for i, value := range []float64{50, 50, 50, 900} {
+			rtt["edge"] = value
+			if err := se.updateMetrics(context.Background(), cfg); err != nil {
+				t.Fatalf("updateMetrics: %v", err)
+			}
+			pod := testPod(fmt.Sprintf("web-%d", i), nil)
+			scores = append(scores, scoresByHost(callPrioritize(t, se, extenderArgs(pod, "edge")))["edge"])
+		}
+		return scores[2] - scores[3]
+	}
+
+	raw, smoothed := drop(1), drop(0.3)
+	if raw <= 0 {
+		t.Fatalf("spike didn't lower the unsmoothed score (drop %d)", raw)
+	}
+	if smoothed <= 0 || float64(smoothed) > 0.4*float64(raw) {
+		t.Errorf("with alpha 0.3 the spike cost %d points, %d unsmoothed", smoothed, raw)
+	}
+}
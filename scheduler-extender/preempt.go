@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	v1core "k8s.io/api/core/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// preempt implements the extender preemption verb (preemptVerb: "preempt"
+// in the scheduler config). The scheduler proposes victims per node; we
+// drop nodes that filter would reject, since evicting pods there would
+// only land the preemptor on a node we consider unfit. Victims on the
+// remaining nodes are returned unchanged.
+func (se *SchedulerExtender) preempt(w http.ResponseWriter, r *http.Request) {
+	cfg := se.config.Load()
+	var args extenderv1.ExtenderPreemptionArgs
+	if err := se.decodeRequest(cfg, r, &args); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startRequestSpan(r, "preempt")
+	defer span.End()
+
+	// The scheduler sends full victims unless it caches nodes, but always
+	// expects meta victims back
+	candidates := args.NodeNameToMetaVictims
+	if candidates == nil {
+		candidates = make(map[string]*extenderv1.MetaVictims, len(args.NodeNameToVictims))
+		for nodeName, victims := range args.NodeNameToVictims {
+			candidates[nodeName] = metaVictims(victims)
+		}
+	}
+	span.SetAttributes(nodeCountAttr(len(candidates)))
+	recordNodeCount(w, len(candidates))
+
+	result := &extenderv1.ExtenderPreemptionResult{NodeNameToMetaVictims: candidates}
+	metricFilters := len(cfg.FilterThresholds) > 0 || cfg.filterExpr != nil
+	if (metricFilters || nodeStateFilters(cfg)) && !se.drained.Load() {
+		if metricFilters {
+			se.refreshIfExpired(ctx)
+		}
+
+		result.NodeNameToMetaVictims = make(map[string]*extenderv1.MetaVictims, len(candidates))
+		for nodeName, victims := range candidates {
+			var node *v1core.Node
+			if se.nodeLister != nil {
+				node, _ = se.nodeLister.Get(nodeName)
+			}
+			if reason := se.filterReason(cfg, args.Pod, nodeName, node, metricFilters); reason != "" {
+				if cfg.Debug {
+					log.Printf("Node %s dropped from preemption: %s", nodeName, reason)
+				}
+				continue
+			}
+			result.NodeNameToMetaVictims[nodeName] = victims
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// metaVictims reduces victims to the pod UIDs the scheduler expects back.
+func metaVictims(victims *extenderv1.Victims) *extenderv1.MetaVictims {
+	if victims == nil {
+		return nil
+	}
+	meta := &extenderv1.MetaVictims{NumPDBViolations: victims.NumPDBViolations}
+	for _, pod := range victims.Pods {
+		meta.Pods = append(meta.Pods, &extenderv1.MetaPod{UID: string(pod.UID)})
+	}
+	return meta
+}
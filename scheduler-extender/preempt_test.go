@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestPreemptDropsFilteredNodes(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.FilterThresholds = map[string]float64{"rtt_p99": 500}
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "good", RTTp99: 20},
+		&NodeMetrics{NodeName: "bad", RTTp99: 900},
+	)
+
+	victim := func(name string) *v1core.Pod { return testPod(name, nil) }
+	body, _ := json.Marshal(extenderv1.ExtenderPreemptionArgs{
+		Pod: testPod("preemptor", nil),
+		NodeNameToVictims: map[string]*extenderv1.Victims{
+			"good": {Pods: []*v1core.Pod{victim("v1"), victim("v2")}, NumPDBViolations: 1},
+			"bad":  {Pods: []*v1core.Pod{victim("v3")}},
+		},
+	})
+	rec := httptest.NewRecorder()
+	se.preempt(rec, httptest.NewRequest(http.MethodPost, "/preempt", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("preempt status %d: %s", rec.Code, rec.Body)
+	}
+
+	var result extenderv1.ExtenderPreemptionResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decoding preemption result: %v: %s", err, rec.Body)
+	}
+	if _, ok := result.NodeNameToMetaVictims["bad"]; ok {
+		t.Error("node failing the filter kept as a preemption candidate")
+	}
+	good := result.NodeNameToMetaVictims["good"]
+	if good == nil {
+		t.Fatalf("node passing the filter dropped: %s", rec.Body)
+	}
+	if good.NumPDBViolations != 1 || len(good.Pods) != 2 || good.Pods[0].UID != "uid-v1" || good.Pods[1].UID != "uid-v2" {
+		t.Errorf("victims on good node = %+v", good)
+	}
+}
+
+func TestPreemptPassesMetaVictimsThrough(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+
+	meta := map[string]*extenderv1.MetaVictims{"a": {Pods: []*extenderv1.MetaPod{{UID: "uid-v1"}}}}
+	body, _ := json.Marshal(extenderv1.ExtenderPreemptionArgs{Pod: testPod("preemptor", nil), NodeNameToMetaVictims: meta})
+	rec := httptest.NewRecorder()
+	se.preempt(rec, httptest.NewRequest(http.MethodPost, "/preempt", bytes.NewReader(body)))
+
+	var result extenderv1.ExtenderPreemptionResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decoding preemption result: %v: %s", err, rec.Body)
+	}
+	if got := result.NodeNameToMetaVictims["a"]; got == nil || len(got.Pods) != 1 || got.Pods[0].UID != "uid-v1" {
+		t.Errorf("meta victims not returned unchanged: %s", rec.Body)
+	}
+}
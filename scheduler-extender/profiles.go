@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	v1core "k8s.io/api/core/v1"
+)
+
+// profileAnnotation selects a named weight profile for a pod, e.g.
+// "mptcp" for workloads that depend on multipath TCP subflows.
+const profileAnnotation = "ebpf-scheduler/profile"
+
+// meshInjectionAnnotations mark pods that get a service mesh sidecar,
+// with the values the mesh's injector sets or honours.
+var meshInjectionAnnotations = map[string]string{
+	"sidecar.istio.io/inject": "true",
+	"linkerd.io/inject":       "enabled",
+}
+
+// meshInjectedAnnotations are added by the injectors themselves, so they
+// catch pods injected through a namespace label too.
+var meshInjectedAnnotations = []string{
+	"sidecar.istio.io/status",
+	"linkerd.io/proxy-version",
+}
+
+// meshInjected reports whether pod runs behind a mesh sidecar.
+func meshInjected(pod *v1core.Pod) bool {
+	for key, value := range meshInjectionAnnotations {
+		if pod.Annotations[key] == value {
+			return true
+		}
+	}
+	for _, key := range meshInjectedAnnotations {
+		if _, ok := pod.Annotations[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WeightProfile is a named set of weights. RequiredMetrics must all be
+// present on a node for the profile to score it; otherwise the node is
+// neutral under this profile.
+type WeightProfile struct {
+	ScoreWeights
+	RequiredMetrics []string `json:"required_metrics,omitempty"`
+
+	// name is the key in Profiles, set on the copies handed out for scoring
+	name string
+}
+
+// podProfile returns the profile to score pod with: the one it names if
+// configured, the mesh profile for meshed pods, the one mapped to its QoS
+// class, otherwise nil for the base weights.
+func podProfile(cfg *ExtenderConfig, pod *v1core.Pod) *WeightProfile {
+	if pod == nil {
+		return nil
+	}
+	name := pod.Annotations[profileAnnotation]
+	if name == "" && meshInjected(pod) {
+		if _, ok := cfg.Profiles[cfg.MeshProfile]; ok {
+			name = cfg.MeshProfile
+		}
+	}
+	if name == "" {
+		name = cfg.QoSProfiles[string(podQOSClass(pod))]
+	}
+	if name == "" {
+		return nil
+	}
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		if cfg.Debug {
+			log.Printf("Pod %s/%s asks for unknown profile %q, using base weights", pod.Namespace, pod.Name, name)
+		}
+		return nil
+	}
+	profile.name = name
+	return &profile
+}
+
+// podQOSClass returns the pod's QoS class as the API server reported it,
+// or derives it from the container resources for pods without status.
+func podQOSClass(pod *v1core.Pod) v1core.PodQOSClass {
+	if pod.Status.QOSClass != "" {
+		return pod.Status.QOSClass
+	}
+
+	containers := append(append([]v1core.Container(nil), pod.Spec.InitContainers...), pod.Spec.Containers...)
+	bestEffort, guaranteed := true, true
+	for _, c := range containers {
+		if len(c.Resources.Requests) > 0 || len(c.Resources.Limits) > 0 {
+			bestEffort = false
+		}
+		for _, resource := range []v1core.ResourceName{v1core.ResourceCPU, v1core.ResourceMemory} {
+			limit, ok := c.Resources.Limits[resource]
+			if !ok {
+				guaranteed = false
+				continue
+			}
+			// Requests default to limits when unset
+			if request, ok := c.Resources.Requests[resource]; ok && request.Cmp(limit) != 0 {
+				guaranteed = false
+			}
+		}
+	}
+	switch {
+	case bestEffort:
+		return v1core.PodQOSBestEffort
+	case guaranteed:
+		return v1core.PodQOSGuaranteed
+	}
+	return v1core.PodQOSBurstable
+}
+
+// ProfileWindow selects Profile between Start and End ("15:04", in the
+// extender's local time zone) on Days ("mon", "tue", ...), or every day
+// if Days is empty. A window whose End is before its Start runs past
+// midnight.
+type ProfileWindow struct {
+	Profile string   `json:"profile"`
+	Start   string   `json:"start"`
+	End     string   `json:"end"`
+	Days    []string `json:"days,omitempty"`
+
+	// start and end are minutes since midnight
+	start, end int
+	days       map[time.Weekday]bool
+}
+
+// parse validates the window against profiles and fills in its parsed form.
+func (pw *ProfileWindow) parse(profiles map[string]WeightProfile) error {
+	if _, ok := profiles[pw.Profile]; !ok {
+		return fmt.Errorf("unknown profile %q", pw.Profile)
+	}
+	for _, bound := range []struct {
+		raw string
+		dst *int
+	}{{pw.Start, &pw.start}, {pw.End, &pw.end}} {
+		t, err := time.Parse("15:04", bound.raw)
+		if err != nil {
+			return fmt.Errorf("invalid time %q, want HH:MM", bound.raw)
+		}
+		*bound.dst = t.Hour()*60 + t.Minute()
+	}
+	pw.days = nil
+	for _, day := range pw.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return fmt.Errorf("invalid day %q", day)
+		}
+		if pw.days == nil {
+			pw.days = make(map[time.Weekday]bool)
+		}
+		pw.days[weekday] = true
+	}
+	return nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// contains reports whether now falls in the window. For windows past
+// midnight the day is the one the window started on.
+func (pw *ProfileWindow) contains(now time.Time) bool {
+	minute := now.Hour()*60 + now.Minute()
+	day := now.Weekday()
+	switch {
+	case pw.start <= pw.end:
+		if minute < pw.start || minute >= pw.end {
+			return false
+		}
+	case minute >= pw.start:
+	case minute < pw.end:
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	return pw.days == nil || pw.days[day]
+}
+
+// scheduledProfile returns the profile of the first window containing
+// now, or nil for the base weights.
+func scheduledProfile(cfg *ExtenderConfig, now time.Time) *WeightProfile {
+	for i := range cfg.ProfileSchedule {
+		if window := &cfg.ProfileSchedule[i]; window.contains(now) {
+			profile := cfg.Profiles[window.Profile]
+			profile.name = window.Profile
+			return &profile
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// headerRoundTripper adds the configured PrometheusHeaders, such as a
+// multi-tenant proxy's X-Scope-OrgID, to every Prometheus request. The
+// headers are read per request so a reload applies to the next query.
+type headerRoundTripper struct {
+	next    http.RoundTripper
+	headers func() map[string]string
+}
+
+func (t *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	headers := t.headers()
+	if len(headers) == 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// tokenRefreshInterval is how long a bearer token read from disk is used
+// before the file is read again.
+const tokenRefreshInterval = time.Minute
+
+// tokenFileRoundTripper sets a bearer token read from the configured
+// PrometheusTokenFile, re-reading it periodically so a rotated token is
+// picked up without a restart.
+type tokenFileRoundTripper struct {
+	next  http.RoundTripper
+	path  func() string
+	clock Clock
+
+	mu       sync.Mutex
+	token    string
+	readFrom string
+	readAt   time.Time
+}
+
+func (t *tokenFileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := t.path()
+	if path == "" {
+		return t.next.RoundTrip(req)
+	}
+	token, err := t.currentToken(path)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(req)
+}
+
+// currentToken returns the token from path, reading the file when the
+// cached token is older than tokenRefreshInterval. If a re-read fails the
+// previous token is kept, since it may well still be valid.
+func (t *tokenFileRoundTripper) currentToken(path string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	if path == t.readFrom && now.Sub(t.readAt) < tokenRefreshInterval {
+		return t.token, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil && len(bytes.TrimSpace(data)) == 0 {
+		err = fmt.Errorf("file is empty")
+	}
+	if err != nil {
+		if path == t.readFrom {
+			log.Printf("Failed to re-read Prometheus token file %s, keeping the previous token: %v", path, err)
+			t.readAt = now
+			return t.token, nil
+		}
+		return "", fmt.Errorf("failed to read Prometheus token file %s: %w", path, err)
+	}
+
+	t.token = string(bytes.TrimSpace(data))
+	t.readFrom = path
+	t.readAt = now
+	return t.token, nil
+}
+
+// checkWarnings logs and counts warnings Prometheus attached to a query
+// answer. With FailOnQueryWarnings they turn the answer into an error.
+func (se *SchedulerExtender) checkWarnings(cfg *ExtenderConfig, name string, warnings v1.Warnings, err error) error {
+	if err != nil || len(warnings) == 0 {
+		return err
+	}
+	se.promMetrics.queryWarnings.WithLabelValues(name).Inc()
+	log.Printf("Prometheus warned about the %s query: %s", name, strings.Join(warnings, "; "))
+	if cfg.FailOnQueryWarnings {
+		return fmt.Errorf("query returned warnings: %s", strings.Join(warnings, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// headerRecorder serves series like fakePrometheus and keeps the headers of
+// every request.
+func headerRecorder(t *testing.T, series promSeries) (*httptest.Server, func() []http.Header) {
+	t.Helper()
+	var mu sync.Mutex
+	var seen []http.Header
+	prom := fakePrometheus(t, series)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Clone())
+		mu.Unlock()
+		prom.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]http.Header(nil), seen...)
+	}
+}
+
+func TestPrometheusHeadersSent(t *testing.T) {
+	prom, headers := headerRecorder(t, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 10}})
+	t.Setenv("PROMETHEUS_URL", prom.URL)
+	t.Setenv("PROMETHEUS_HEADERS", "X-Scope-OrgID=tenant-a, X-Team=edge")
+	t.Setenv("DEBUG", "false")
+	se, err := NewSchedulerExtender()
+	if err != nil {
+		t.Fatalf("NewSchedulerExtender: %v", err)
+	}
+
+	if err := se.updateMetrics(context.Background(), se.config.Load()); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+	seen := headers()
+	if len(seen) == 0 {
+		t.Fatal("no requests reached Prometheus")
+	}
+	for i, h := range seen {
+		if h.Get("X-Scope-OrgID") != "tenant-a" || h.Get("X-Team") != "edge" {
+			t.Errorf("request %d headers %v lack the configured headers", i, h)
+		}
+	}
+}
+
+func TestTokenFileReread(t *testing.T) {
+	prom, headers := headerRecorder(t, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 10}})
+	path := filepath.Join(t.TempDir(), "token")
+	writeToken := func(token string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock := newFakeClock()
+	client := &http.Client{Transport: &tokenFileRoundTripper{
+		next:  http.DefaultTransport,
+		path:  func() string { return path },
+		clock: clock,
+	}}
+	lastAuth := func() string {
+		t.Helper()
+		resp, err := client.Get(prom.URL + "/api/v1/query?query=ebpf_rtt_p99_milliseconds")
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		resp.Body.Close()
+		seen := headers()
+		return seen[len(seen)-1].Get("Authorization")
+	}
+
+	writeToken("first")
+	if got := lastAuth(); got != "Bearer first" {
+		t.Fatalf("Authorization = %q, want Bearer first", got)
+	}
+
+	// A rotated token is picked up once the cached one has aged out
+	writeToken("second")
+	if got := lastAuth(); got != "Bearer first" {
+		t.Errorf("before refresh interval: Authorization = %q, want Bearer first", got)
+	}
+	clock.advance(tokenRefreshInterval)
+	if got := lastAuth(); got != "Bearer second" {
+		t.Errorf("after refresh interval: Authorization = %q, want Bearer second", got)
+	}
+
+	// An unreadable file keeps the previous token
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(tokenRefreshInterval)
+	if got := lastAuth(); got != "Bearer second" {
+		t.Errorf("after the file vanished: Authorization = %q, want Bearer second", got)
+	}
+}
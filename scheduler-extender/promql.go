@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// promqlKeywords are identifiers that never name a metric.
+var promqlKeywords = map[string]bool{
+	"by": true, "without": true, "on": true, "ignoring": true,
+	"group_left": true, "group_right": true, "and": true, "or": true,
+	"unless": true, "bool": true, "offset": true, "inf": true, "nan": true,
+	"sum": true, "avg": true, "min": true, "max": true, "count": true,
+	"stddev": true, "stdvar": true, "topk": true, "bottomk": true,
+	"quantile": true, "count_values": true, "group": true,
+}
+
+// injectMatchers adds label matchers to every metric selector in a PromQL
+// expression, merging them into an existing {...} selector when present.
+// It is a lightweight scanner rather than a full parser, which is enough
+// for the metric queries the extender issues.
+func injectMatchers(query string, matchers map[string]string) string {
+	if len(matchers) == 0 {
+		return query
+	}
+
+	keys := make([]string, 0, len(matchers))
+	for k := range matchers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+strconv.Quote(matchers[k]))
+	}
+	injected := strings.Join(parts, ",")
+
+	var out strings.Builder
+	grouping := false // inside by (...) / on (...) label lists
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			end := skipString(query, i)
+			out.WriteString(query[i:end])
+			i = end
+		case c == '[':
+			end := strings.IndexByte(query[i:], ']')
+			if end < 0 {
+				out.WriteString(query[i:])
+				return out.String()
+			}
+			out.WriteString(query[i : i+end+1])
+			i += end + 1
+		case c == '{':
+			// Selector without a metric name, e.g. {__name__="x"}
+			end := selectorEnd(query, i)
+			out.WriteString(mergeSelector(query[i:end], injected))
+			i = end
+		case isIdentStart(c):
+			j := i
+			for j < len(query) && isIdentChar(query[j]) {
+				j++
+			}
+			ident := query[i:j]
+			out.WriteString(ident)
+			i = j
+
+			next := i
+			for next < len(query) && query[next] == ' ' {
+				next++
+			}
+
+			switch {
+			case promqlKeywords[ident]:
+				switch ident {
+				case "by", "without", "on", "ignoring", "group_left", "group_right":
+					grouping = next < len(query) && query[next] == '('
+				}
+			case grouping:
+				// label name inside a grouping clause
+			case next < len(query) && query[next] == '(':
+				// function call
+			case next < len(query) && query[next] == '{':
+				end := selectorEnd(query, next)
+				out.WriteString(query[i:next])
+				out.WriteString(mergeSelector(query[next:end], injected))
+				i = end
+			default:
+				out.WriteString("{" + injected + "}")
+			}
+		case c >= '0' && c <= '9' || c == '.':
+			// numbers and durations such as 1e3 or "offset 5m"
+			j := i
+			for j < len(query) && (isIdentChar(query[j]) || query[j] == '.') {
+				j++
+			}
+			out.WriteString(query[i:j])
+			i = j
+		case c == ')':
+			grouping = false
+			out.WriteByte(c)
+			i++
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+
+	return out.String()
+}
+
+// mergeSelector adds injected matchers to an existing "{...}" selector.
+func mergeSelector(selector, injected string) string {
+	inner := strings.TrimSpace(selector[1 : len(selector)-1])
+	inner = strings.TrimSuffix(inner, ",")
+	if inner == "" {
+		return "{" + injected + "}"
+	}
+	return "{" + inner + "," + injected + "}"
+}
+
+// selectorEnd returns the index just past the "}" closing the selector at i.
+func selectorEnd(query string, i int) int {
+	for j := i + 1; j < len(query); {
+		switch query[j] {
+		case '"', '\'', '`':
+			j = skipString(query, j)
+		case '}':
+			return j + 1
+		default:
+			j++
+		}
+	}
+	return len(query)
+}
+
+// skipString returns the index just past the quoted string starting at i.
+func skipString(query string, i int) int {
+	quote := query[i]
+	for j := i + 1; j < len(query); j++ {
+		if query[j] == '\\' && quote != '`' {
+			j++
+			continue
+		}
+		if query[j] == quote {
+			return j + 1
+		}
+	}
+	return len(query)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
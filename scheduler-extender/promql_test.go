@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func TestInjectMatchers(t *testing.T) {
+	matchers := map[string]string{"cluster": "prod", "region": "eu"}
+	tests := []struct{ query, want string }{
+		{`ebpf_rtt_p99_milliseconds`, `ebpf_rtt_p99_milliseconds{cluster="prod",region="eu"}`},
+		{`ebpf_drop_rate{iface="eth0"}`, `ebpf_drop_rate{iface="eth0",cluster="prod",region="eu"}`},
+		{`ebpf_drop_rate{}`, `ebpf_drop_rate{cluster="prod",region="eu"}`},
+		{`rate(ebpf_program_cpu_seconds[1m])`, `rate(ebpf_program_cpu_seconds{cluster="prod",region="eu"}[1m])`},
+		{`sum by (node) (ebpf_tcp_retrans_rate)`, `sum by (node) (ebpf_tcp_retrans_rate{cluster="prod",region="eu"})`},
+		{`a / on (node) b`, `a{cluster="prod",region="eu"} / on (node) b{cluster="prod",region="eu"}`},
+		{`{__name__="ebpf_mem_pressure"}`, `{__name__="ebpf_mem_pressure",cluster="prod",region="eu"}`},
+		{`ebpf_x{path="a}b"} > 0.5`, `ebpf_x{path="a}b",cluster="prod",region="eu"} > 0.5`},
+	}
+	for _, tt := range tests {
+		if got := injectMatchers(tt.query, matchers); got != tt.want {
+			t.Errorf("injectMatchers(%q)\n got %s\nwant %s", tt.query, got, tt.want)
+		}
+	}
+
+	if got := injectMatchers("ebpf_drop_rate", nil); got != "ebpf_drop_rate" {
+		t.Errorf("no matchers changed the query to %s", got)
+	}
+}
+
+func TestRefreshQueriesCarryQueryLabels(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		queries = append(queries, r.Form.Get("query"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer prom.Close()
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.QueryLabels = map[string]string{"cluster": "prod"} })
+	se := newTestExtender(t, cfg, nil)
+	client, _ := api.NewClient(api.Config{Address: prom.URL})
+	se.promClient = v1.NewAPI(client)
+	// Every answer is empty; only the query strings matter here
+	se.updateMetrics(context.Background(), cfg)
+
+	if len(queries) == 0 {
+		t.Fatal("no queries issued")
+	}
+	for _, query := range queries {
+		if !strings.Contains(query, `cluster="prod"`) {
+			t.Errorf("query %s lacks the cluster matcher", query)
+		}
+	}
+}
+
+func TestFallbackQueryWhenRecordingRuleEmpty(t *testing.T) {
+	const fallback = "histogram_quantile(0.99, sum by (le, node) (rate(ebpf_rtt_seconds_bucket[5m]))) * 1000"
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.FallbackQueries = map[string]string{"rtt_p99": fallback}
+	})
+	rtt := func(series promSeries) float64 {
+		t.Helper()
+		se := newTestExtender(t, cfg, series)
+		if err := se.updateMetrics(context.Background(), cfg); err != nil {
+			t.Fatalf("updateMetrics: %v", err)
+		}
+		se.mu.RLock()
+		defer se.mu.RUnlock()
+		m, ok := se.metricsCache["a"]
+		if !ok {
+			t.Fatal("node a missing from the cache")
+		}
+		return m.RTTp99
+	}
+
+	// No recording rule: the raw histogram answers
+	if got := rtt(promSeries{"ebpf_rtt_seconds_bucket": {"a": 42}}); got != 42 {
+		t.Errorf("with the recording rule empty: RTT %v, want 42 from the fallback", got)
+	}
+	// The recording rule wins when it has data
+	if got := rtt(promSeries{"ebpf_rtt_p99_milliseconds": {"a": 10}, "ebpf_rtt_seconds_bucket": {"a": 42}}); got != 10 {
+		t.Errorf("with the recording rule present: RTT %v, want 10", got)
+	}
+}
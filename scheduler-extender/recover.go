@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// withRecovery turns a panic in an extender verb into a 500 so the
+// scheduler gets an answer it can act on instead of a dropped connection.
+func withRecovery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			switch err := recover(); err {
+			case nil:
+			case http.ErrAbortHandler:
+				// Deliberate aborts are for net/http to handle
+				panic(err)
+			default:
+				log.Printf("Panic serving %s: %v\n%s", r.URL.Path, err, debug.Stack())
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+		}()
+		next(w, r)
+	}
+}
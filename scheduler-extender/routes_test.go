@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var (
+	schedulerPaths = []string{"/filter", "/prioritize", "/preempt", "/reload", "/drain", "/inject", "/undrain", "/cache/clear"}
+	metricsPaths   = []string{"/metrics", "/prom-metrics", "/health", "/readyz", "/verify", "/history", "/worst", "/config", "/snapshot"}
+)
+
+// routed reports whether mux has a handler registered for path.
+func routed(mux *http.ServeMux, path string) bool {
+	_, pattern := mux.Handler(httptest.NewRequest(http.MethodGet, path, nil))
+	return pattern != ""
+}
+
+func TestRoutesSplitWithMetricsAddr(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.MetricsAddr = ":9090" })
+	se := newTestExtender(t, cfg, nil)
+	schedulerMux, metricsMux := se.routes(cfg)
+
+	for _, path := range schedulerPaths {
+		if !routed(schedulerMux, path) {
+			t.Errorf("%s not served on the scheduler listener", path)
+		}
+		if routed(metricsMux, path) {
+			t.Errorf("%s served on the metrics listener", path)
+		}
+	}
+	for _, path := range metricsPaths {
+		if !routed(metricsMux, path) {
+			t.Errorf("%s not served on the metrics listener", path)
+		}
+		if routed(schedulerMux, path) {
+			t.Errorf("%s served on the scheduler listener", path)
+		}
+	}
+
+	// Each server answers its own endpoints end to end
+	schedulerSrv := httptest.NewServer(schedulerMux)
+	defer schedulerSrv.Close()
+	metricsSrv := httptest.NewServer(metricsMux)
+	defer metricsSrv.Close()
+	for srv, want := range map[*httptest.Server]map[string]int{
+		schedulerSrv: {"/health": http.StatusNotFound, "/prioritize": http.StatusBadRequest},
+		metricsSrv:   {"/health": http.StatusOK, "/prioritize": http.StatusNotFound},
+	} {
+		for path, code := range want {
+			resp, err := http.Post(srv.URL+path, "application/json", nil)
+			if err != nil {
+				t.Fatalf("POST %s: %v", path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != code {
+				t.Errorf("POST %s%s: status %d, want %d", srv.URL, path, resp.StatusCode, code)
+			}
+		}
+	}
+}
+
+func TestRoutesSharedWithoutMetricsAddr(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	schedulerMux, metricsMux := se.routes(cfg)
+
+	if schedulerMux != metricsMux {
+		t.Fatal("separate muxes without MetricsAddr")
+	}
+	for _, path := range append(schedulerPaths, metricsPaths...) {
+		if !routed(schedulerMux, path) {
+			t.Errorf("%s not served", path)
+		}
+	}
+}
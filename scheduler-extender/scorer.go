@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"math"
+)
+
+// Scorer combines a node's weighted metrics into a score between minScore
+// and maxScore. components holds each weighted metric's value, normalized
+// value (1 is best) and weight, with weights summing to 1; Score fills in
+// each Contribution for the breakdown.
+type Scorer interface {
+	Score(metrics *NodeMetrics, cfg *ExtenderConfig, components map[string]ScoreComponent) float64
+}
+
+// Scorer names for the SCORER setting.
+const (
+	scorerWeighted  = "weighted"
+	scorerGeometric = "geometric"
+)
+
+// scorers holds the available scoring models by name. Register new ones
+// from an init function with registerScorer.
+var scorers = map[string]Scorer{
+	scorerWeighted:  WeightedScorer{},
+	scorerGeometric: GeometricScorer{},
+}
+
+func registerScorer(name string, scorer Scorer) {
+	scorers[name] = scorer
+}
+
+// WeightedScorer is the weighted sum of normalized metrics.
+type WeightedScorer struct{}
+
+func (WeightedScorer) Score(metrics *NodeMetrics, cfg *ExtenderConfig, components map[string]ScoreComponent) float64 {
+	score := 0.0
+	for name, c := range components {
+		c.Contribution = c.Weight * c.Normalized * maxScore
+		components[name] = c
+		score += c.Contribution
+	}
+	return score
+}
+
+// GeometricScorer is the weighted geometric mean of normalized metrics, so
+// one near-zero metric drags the whole score down instead of being masked
+// by the others. Each Contribution is that metric's factor on a 0-100
+// scale; the score is their product, rescaled.
+type GeometricScorer struct{}
+
+func (GeometricScorer) Score(metrics *NodeMetrics, cfg *ExtenderConfig, components map[string]ScoreComponent) float64 {
+	if len(components) == 0 {
+		return minScore
+	}
+	product := 1.0
+	for name, c := range components {
+		factor := math.Pow(c.Normalized, c.Weight)
+		c.Contribution = factor * maxScore
+		components[name] = c
+		product *= factor
+	}
+	return product * maxScore
+}
+
+// scoreComponents normalizes every weighted metric of a node. Weights are
+// relative, so {rtt: 3, cpu: 1} means 0.75/0.25.
+func scoreComponents(cfg *ExtenderConfig, nodeName string, metrics *NodeMetrics, weights *ScoreWeights, cpuRequestCores float64) map[string]ScoreComponent {
+	total := totalWeight(cfg, weights)
+	components := make(map[string]ScoreComponent)
+	add := func(spec metricSpec, weight float64) {
+		if weight == 0 || cfg.disabledMetrics[spec.name] {
+			return
+		}
+		value, ok := spec.get(metrics)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			log.Printf("Warning: node %s has non-finite %s (%v), treating as worst case", nodeName, spec.name, value)
+			value = spec.worst()
+		}
+		logScale := cfg.MetricScaling[spec.name] == scalingLog
+		normalized := normalizeMetric(value, spec.min, spec.max, spec.lowerIsBetter, logScale)
+		if !ok {
+			normalized = missingNormalized(cfg, spec.name, normalized)
+		}
+		inverted := weight < 0
+		if inverted {
+			normalized, weight = 1-normalized, -weight
+		} else if spec.name == "cpu_util" {
+			normalized = scaleCPUPenalty(cfg, normalized, cpuRequestCores)
+		}
+		confidence := sampleConfidence(cfg, metrics, spec.name)
+		if confidence < 1 {
+			normalized = confidence*normalized + (1-confidence)*cfg.NeutralScore/maxScore
+		}
+		components[spec.name] = ScoreComponent{
+			Value:      value,
+			Normalized: normalized,
+			Weight:     weight / total,
+			Inverted:   inverted,
+			Confidence: confidence,
+		}
+	}
+
+	for _, spec := range metricSpecs {
+		add(spec, *spec.weight(weights))
+	}
+	for i := range cfg.CustomMetrics {
+		c := &cfg.CustomMetrics[i]
+		add(c.spec(), c.Weight)
+	}
+	return components
+}
+
+// Treatments of a missing value for MissingValues.
+const (
+	missingNeutral = "neutral"
+	missingBest    = "best"
+	missingWorst   = "worst"
+)
+
+// missingNormalized returns the normalized value to score a missing metric
+// with. normalized, computed from the zero value, is kept for metrics
+// without a configured treatment.
+func missingNormalized(cfg *ExtenderConfig, name string, normalized float64) float64 {
+	switch cfg.MissingValues[name] {
+	case missingNeutral:
+		return cfg.NeutralScore / maxScore
+	case missingBest:
+		return 1
+	case missingWorst:
+		return 0
+	}
+	return normalized
+}
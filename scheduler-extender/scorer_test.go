@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// countingScorer scores every node by its RTT and counts its calls.
+type countingScorer struct {
+	calls *int
+}
+
+func (s countingScorer) Score(metrics *NodeMetrics, cfg *ExtenderConfig, components map[string]ScoreComponent) float64 {
+	*s.calls++
+	return maxScore - metrics.RTTp99/10
+}
+
+func TestCustomScorerInvoked(t *testing.T) {
+	calls := 0
+	registerScorer("rtt-only", countingScorer{calls: &calls})
+	t.Cleanup(func() { delete(scorers, "rtt-only") })
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.Scorer = "rtt-only" })
+	scores := metricScores(t, cfg,
+		&NodeMetrics{NodeName: "a", RTTp99: 100, CPUUtil: 99},
+		&NodeMetrics{NodeName: "b", RTTp99: 300},
+	)
+	if calls != 2 {
+		t.Errorf("custom scorer called %d times for 2 nodes", calls)
+	}
+	if math.Abs(scores["a"]-90) > 1e-9 || math.Abs(scores["b"]-70) > 1e-9 {
+		t.Errorf("scores %v, want the custom scorer's 90 and 70", scores)
+	}
+
+	if validateConfig(testConfig(t, func(cfg *ExtenderConfig) { cfg.Scorer = "rtt-only" })) != nil {
+		t.Error("registered scorer rejected")
+	}
+	cfg.Scorer = "unregistered"
+	if validateConfig(cfg) == nil {
+		t.Error("unknown scorer accepted")
+	}
+}
+
+func TestGeometricPenalizesOneBadMetric(t *testing.T) {
+	nodes := func() []*NodeMetrics {
+		return []*NodeMetrics{
+			// Excellent except for a terrible drop rate
+			{NodeName: "lopsided", RTTp99: 5, RetransRate: 0, DropRate: 990, RunqlatP95: 1, CPUUtil: 5},
+			// Mediocre everywhere
+			{NodeName: "even", RTTp99: 400, RetransRate: 40, DropRate: 400, RunqlatP95: 40, CPUUtil: 40},
+		}
+	}
+
+	sum := metricScores(t, testConfig(t, nil), nodes()...)
+	if sum["lopsided"] <= sum["even"] {
+		t.Fatalf("sum: lopsided node scored %v, even node %v; want the bad metric masked", sum["lopsided"], sum["even"])
+	}
+	geometric := metricScores(t, testConfig(t, func(cfg *ExtenderConfig) { cfg.Scorer = scorerGeometric }), nodes()...)
+	if geometric["lopsided"] >= geometric["even"] {
+		t.Errorf("geometric: lopsided node scored %v, even node %v", geometric["lopsided"], geometric["even"])
+	}
+}
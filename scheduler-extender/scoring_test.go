@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// metricScores scores each entry under cfg with no pod-specific options.
+func metricScores(t *testing.T, cfg *ExtenderConfig, entries ...*NodeMetrics) map[string]float64 {
+	t.Helper()
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, entries...)
+	scores := make(map[string]float64, len(entries))
+	for _, m := range entries {
+		scores[m.NodeName] = se.calculateNodeScore(cfg, m.NodeName, scoreOptions{})
+	}
+	return scores
+}
+
+// weightedScores weights metric at 0.2 on top of the defaults and scores
+// two otherwise identical nodes holding good and bad values of it.
+func weightedScores(t *testing.T, metric string, good, bad float64) (goodScore, badScore float64) {
+	t.Helper()
+	spec := lookupMetricSpec(metric)
+	if spec == nil {
+		t.Fatalf("unknown metric %s", metric)
+	}
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { *spec.weight(&cfg.Weights) = 0.2 })
+	goodNode := &NodeMetrics{NodeName: "good", RTTp99: 50}
+	badNode := &NodeMetrics{NodeName: "bad", RTTp99: 50}
+	*spec.value(goodNode) = good
+	*spec.value(badNode) = bad
+	scores := metricScores(t, cfg, goodNode, badNode)
+	return scores["good"], scores["bad"]
+}
+
+func TestZeroWindowRateLowersScore(t *testing.T) {
+	good, bad := weightedScores(t, "zero_window_rate", 0, 80)
+	if bad >= good {
+		t.Errorf("node with 80 zero-window events/s scored %v, clean node %v", bad, good)
+	}
+}
+
+func TestAQMNodeRanksAboveTailDrop(t *testing.T) {
+	nodes := func() []*NodeMetrics {
+		return []*NodeMetrics{
+			{NodeName: "aqm", RTTp99: 100, DropRate: 50, AQMScore: 0.9},
+			{NodeName: "tail-drop", RTTp99: 100, DropRate: 50, AQMScore: 0},
+		}
+	}
+
+	scores := metricScores(t, testConfig(t, func(cfg *ExtenderConfig) { cfg.Weights.AQMScore = 0.2 }), nodes()...)
+	if scores["aqm"] <= scores["tail-drop"] {
+		t.Errorf("AQM node scored %v, tail-drop node %v", scores["aqm"], scores["tail-drop"])
+	}
+
+	// Off by default, so existing rankings don't move
+	scores = metricScores(t, testConfig(t, nil), nodes()...)
+	if math.Abs(scores["aqm"]-scores["tail-drop"]) > 1e-9 {
+		t.Errorf("default weights: AQM node scored %v, tail-drop node %v", scores["aqm"], scores["tail-drop"])
+	}
+}
+
+func TestRetransConnectionsEndToEnd(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.Weights.RetransConnections = 0.3 })
+	se := newTestExtender(t, cfg, promSeries{
+		"ebpf_tcp_retrans_connections": {"widespread": 80, "clean": 0},
+		"ebpf_rtt_p99_milliseconds":    {"widespread": 50, "clean": 50},
+	})
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+	if got := se.metricsCache["widespread"].RetransConnections; got != 80 {
+		t.Fatalf("RetransConnections = %v after refresh, want 80", got)
+	}
+
+	widespread := se.calculateNodeScore(cfg, "widespread", scoreOptions{})
+	clean := se.calculateNodeScore(cfg, "clean", scoreOptions{})
+	if widespread >= clean {
+		t.Errorf("node with 80 retransmitting connections scored %v, clean node %v", widespread, clean)
+	}
+	if c := se.metricsCache["widespread"].Breakdown["retrans_connections"]; c.Value != 80 || c.Weight <= 0 {
+		t.Errorf("retrans_connections breakdown = %+v", c)
+	}
+}
+
+func TestPacketProcP99LowersScore(t *testing.T) {
+	good, bad := weightedScores(t, "packet_proc_p99", 20, 900)
+	if bad >= good {
+		t.Errorf("node with 900us packet-processing p99 scored %v, 20us node %v", bad, good)
+	}
+}
+
+func TestMemPressureContributes(t *testing.T) {
+	good, bad := weightedScores(t, "mem_pressure", 5, 90)
+	if bad >= good {
+		t.Errorf("node at 90%% memory pressure scored %v, node at 5%% %v", bad, good)
+	}
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.Weights.MemPressure = 0.2 })
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 50, MemPressure: 40})
+	se.calculateNodeScore(cfg, "a", scoreOptions{})
+	c, ok := se.metricsCache["a"].Breakdown["mem_pressure"]
+	if !ok || c.Value != 40 || c.Contribution <= 0 {
+		t.Errorf("mem_pressure breakdown = %+v, %v", c, ok)
+	}
+
+	// Configs predating the weight leave it out
+	cfg = testConfig(t, nil)
+	se = newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 50, MemPressure: 40})
+	se.calculateNodeScore(cfg, "a", scoreOptions{})
+	if c, ok := se.metricsCache["a"].Breakdown["mem_pressure"]; ok && c.Contribution != 0 {
+		t.Errorf("mem_pressure contributed %v without a weight", c.Contribution)
+	}
+}
+
+func TestFDUtilLowersScoreAndFilters(t *testing.T) {
+	good, bad := weightedScores(t, "fd_util", 10, 97)
+	if bad >= good {
+		t.Errorf("node at 97%% of its FD limit scored %v, node at 10%% %v", bad, good)
+	}
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.FilterThresholds = map[string]float64{"fd_util": 95}
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "ok", RTTp99: 10, FDUtil: 60},
+		&NodeMetrics{NodeName: "exhausted", RTTp99: 10, FDUtil: 97},
+	)
+	result := callFilter(t, se, extenderArgs(testPod("web", nil), "ok", "exhausted"))
+	if _, failed := result.FailedNodes["exhausted"]; !failed {
+		t.Error("node above the fd_util threshold passed the filter")
+	}
+	if _, failed := result.FailedNodes["ok"]; failed {
+		t.Errorf("node below the threshold failed: %s", result.FailedNodes["ok"])
+	}
+}
+
+func TestNaNRTTScoresWorstCase(t *testing.T) {
+	cfg := testConfig(t, nil)
+	scores := metricScores(t, cfg,
+		&NodeMetrics{NodeName: "nan", RTTp99: math.NaN()},
+		&NodeMetrics{NodeName: "inf", RTTp99: math.Inf(1)},
+		&NodeMetrics{NodeName: "good", RTTp99: 5},
+	)
+	for _, name := range []string{"nan", "inf"} {
+		score := scores[name]
+		if math.IsNaN(score) || math.IsInf(score, 0) || score < minScore || score > maxScore {
+			t.Errorf("%s RTT scored %v, want a finite score in range", name, score)
+		}
+		if score >= scores["good"] {
+			t.Errorf("%s RTT scored %v, not below the healthy node's %v", name, score, scores["good"])
+		}
+	}
+}
+
+func TestBufferbloatLowersScore(t *testing.T) {
+	// Both nodes share an idle RTT of 50ms; only one queues under load
+	good, bad := weightedScores(t, "bufferbloat", 2, 300)
+	if bad >= good {
+		t.Errorf("bufferbloated node scored %v, clean node %v", bad, good)
+	}
+}
+
+func TestEBPFProgramCPUMinorEffect(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.Weights.EBPFProgramCPU = 0.05 })
+	scores := metricScores(t, cfg,
+		&NodeMetrics{NodeName: "light", RTTp99: 50, EBPFProgramCPU: 0.05},
+		&NodeMetrics{NodeName: "heavy", RTTp99: 50, EBPFProgramCPU: 0.9},
+		&NodeMetrics{NodeName: "slow", RTTp99: 400, EBPFProgramCPU: 0.05},
+	)
+	if scores["heavy"] >= scores["light"] {
+		t.Errorf("overhead-heavy node scored %v, light node %v", scores["heavy"], scores["light"])
+	}
+	// Overhead nudges the ranking; it doesn't outweigh real network problems
+	if scores["heavy"] <= scores["slow"] {
+		t.Errorf("overhead-heavy node scored %v, below the high-RTT node's %v", scores["heavy"], scores["slow"])
+	}
+	if diff := scores["light"] - scores["heavy"]; diff > 10 {
+		t.Errorf("overhead cost %v points, want a minor effect", diff)
+	}
+}
+
+func TestConnEstablishLatencyLowersScore(t *testing.T) {
+	good, bad := weightedScores(t, "conn_establish_latency_p95", 2, 600)
+	if bad >= good {
+		t.Errorf("node with 600ms handshakes scored %v, 2ms node %v", bad, good)
+	}
+}
+
+func TestCPURequestScalesCPUPenalty(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "busy", RTTp99: 50, CPUUtil: 80},
+		&NodeMetrics{NodeName: "idle", RTTp99: 50, CPUUtil: 0},
+	)
+
+	light := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("light", nil), "busy", "idle")))
+	heavy := scoresByHost(callPrioritize(t, se, extenderArgs(replica(0, "4"), "busy", "idle")))
+	if heavy["busy"] >= light["busy"] {
+		t.Errorf("busy node scored %d for a 4-core pod, %d for a zero-request pod", heavy["busy"], light["busy"])
+	}
+	if heavy["idle"] != light["idle"] {
+		t.Errorf("idle node scored %d for a 4-core pod, %d for a zero-request pod", heavy["idle"], light["idle"])
+	}
+
+	// Zero requests score exactly as without request scaling
+	if want := int64(se.calculateNodeScore(cfg, "busy", scoreOptions{})); light["busy"] != want {
+		t.Errorf("zero-request pod scored %d on the busy node, want %d", light["busy"], want)
+	}
+}
+
+func TestRTORateLowersScoreAndFilters(t *testing.T) {
+	good, bad := weightedScores(t, "rto_rate", 0, 20)
+	if bad >= good {
+		t.Errorf("node with 20 RTOs/s scored %v, clean node %v", bad, good)
+	}
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.FilterThresholds = map[string]float64{"rto_rate": 5}
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "ok", RTTp99: 10, RTORate: 1},
+		&NodeMetrics{NodeName: "timing-out", RTTp99: 10, RTORate: 20},
+	)
+	result := callFilter(t, se, extenderArgs(testPod("web", nil), "ok", "timing-out"))
+	if _, failed := result.FailedNodes["timing-out"]; !failed {
+		t.Error("node above the rto_rate threshold passed the filter")
+	}
+	if _, failed := result.FailedNodes["ok"]; failed {
+		t.Errorf("node below the threshold failed: %s", result.FailedNodes["ok"])
+	}
+}
+
+func TestSocketBufferPressureLowersScore(t *testing.T) {
+	good, bad := weightedScores(t, "socket_buffer_pressure", 3, 85)
+	if bad >= good {
+		t.Errorf("node with full socket buffers 85%% of the time scored %v, clean node %v", bad, good)
+	}
+}
+
+func TestRouteFlappingLowersScoreAndFilters(t *testing.T) {
+	good, bad := weightedScores(t, "route_changes_rate", 0, 4)
+	if bad >= good {
+		t.Errorf("flapping node scored %v, stable node %v", bad, good)
+	}
+
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.FilterThresholds = map[string]float64{"route_changes_rate": 2}
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "stable", RTTp99: 10, RouteChangesRate: 0.1},
+		&NodeMetrics{NodeName: "flapping", RTTp99: 10, RouteChangesRate: 4},
+	)
+	result := callFilter(t, se, extenderArgs(testPod("web", nil), "stable", "flapping"))
+	if _, failed := result.FailedNodes["flapping"]; !failed {
+		t.Error("node above the route_changes_rate threshold passed the filter")
+	}
+	if _, failed := result.FailedNodes["stable"]; failed {
+		t.Errorf("stable node failed: %s", result.FailedNodes["stable"])
+	}
+}
+
+func TestMPTCPSubflowHealthForMPTCPPods(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		weights := cfg.Weights
+		weights.MPTCPSubflowHealth = 0.3
+		cfg.Profiles = map[string]WeightProfile{"mptcp": {ScoreWeights: weights}}
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "healthy", RTTp99: 50, MPTCPSubflowHealth: 95},
+		&NodeMetrics{NodeName: "degraded", RTTp99: 50, MPTCPSubflowHealth: 20},
+	)
+
+	mptcp := testPod("mptcp", map[string]string{profileAnnotation: "mptcp"})
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(mptcp, "healthy", "degraded")))
+	if scores["healthy"] <= scores["degraded"] {
+		t.Errorf("MPTCP pod: healthy subflows scored %d, degraded %d", scores["healthy"], scores["degraded"])
+	}
+
+	// Pods not using MPTCP don't care about subflows
+	scores = scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), "healthy", "degraded")))
+	if scores["healthy"] != scores["degraded"] {
+		t.Errorf("plain pod: healthy subflows scored %d, degraded %d", scores["healthy"], scores["degraded"])
+	}
+}
+
+func TestRawWeightsNormalized(t *testing.T) {
+	nodes := func() []*NodeMetrics {
+		return []*NodeMetrics{
+			{NodeName: "fast-busy", RTTp99: 20, CPUUtil: 90},
+			{NodeName: "slow-idle", RTTp99: 400, CPUUtil: 5},
+			{NodeName: "middling", RTTp99: 200, CPUUtil: 50},
+		}
+	}
+	weighted := func(rtt, cpu float64) map[string]float64 {
+		return metricScores(t, testConfig(t, func(cfg *ExtenderConfig) {
+			cfg.Weights = ScoreWeights{RTTp99: rtt, CPUUtil: cpu}
+		}), nodes()...)
+	}
+
+	raw := weighted(3, 1)
+	normalized := weighted(0.75, 0.25)
+	for name, score := range normalized {
+		if math.Abs(raw[name]-score) > 1e-9 {
+			t.Errorf("%s scored %v with weights 3:1, %v with 0.75:0.25", name, raw[name], score)
+		}
+	}
+	if !(raw["fast-busy"] > raw["middling"] && raw["middling"] > raw["slow-idle"]) {
+		t.Errorf("RTT-heavy weights ranked %v", raw)
+	}
+}
+
+func TestSidecarLatencyForMeshedPods(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		weights := cfg.Weights
+		weights.SidecarLatencyP95 = 0.3
+		cfg.Profiles = map[string]WeightProfile{"mesh": {ScoreWeights: weights}}
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "quiet", RTTp99: 50, SidecarLatencyP95: 2},
+		&NodeMetrics{NodeName: "loaded", RTTp99: 50, SidecarLatencyP95: 80},
+	)
+
+	for _, annotations := range []map[string]string{
+		{"sidecar.istio.io/inject": "true"},
+		{"linkerd.io/proxy-version": "stable-2.14"},
+	} {
+		scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("meshed", annotations), "quiet", "loaded")))
+		if scores["quiet"] <= scores["loaded"] {
+			t.Errorf("meshed pod %v: quiet sidecars scored %d, loaded %d", annotations, scores["quiet"], scores["loaded"])
+		}
+	}
+
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("plain", nil), "quiet", "loaded")))
+	if scores["quiet"] != scores["loaded"] {
+		t.Errorf("pod without a sidecar: quiet scored %d, loaded %d", scores["quiet"], scores["loaded"])
+	}
+}
+
+func TestProfileRequiredMetrics(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.Profiles = map[string]WeightProfile{
+			"latency": {ScoreWeights: ScoreWeights{RTTp99: 1}, RequiredMetrics: []string{"rtt_p99"}},
+			"cpu":     {ScoreWeights: ScoreWeights{RTTp99: 0.2, CPUUtil: 0.8}, RequiredMetrics: []string{"cpu_util"}},
+		}
+	})
+	// Prometheus has RTT for the node but no CPU utilization
+	se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 20}})
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+
+	profiled := func(profile string) int64 {
+		t.Helper()
+		pod := testPod(profile, map[string]string{profileAnnotation: profile})
+		return scoresByHost(callPrioritize(t, se, extenderArgs(pod, "a")))["a"]
+	}
+	if score := profiled("latency"); score == int64(cfg.NeutralScore) {
+		t.Errorf("latency profile scored the node neutral despite its RTT")
+	}
+	if score := profiled("cpu"); score != int64(cfg.NeutralScore) {
+		t.Errorf("cpu profile scored %d without CPU utilization, want neutral", score)
+	}
+}
+
+func TestCwndCollapseLowersScore(t *testing.T) {
+	good, bad := weightedScores(t, "cwnd_collapse_rate", 0, 30)
+	if bad >= good {
+		t.Errorf("node with 30 cwnd collapses/s scored %v, clean node %v", bad, good)
+	}
+}
+
+func TestLowCoverageFilterPassesThrough(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.MinFilterCoverage = 50
+		cfg.FilterThresholds = map[string]float64{"drop_rate": 100}
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "lossy", RTTp99: 10, DropRate: 900})
+
+	// One of four candidates has metrics: 25% coverage
+	result := callFilter(t, se, extenderArgs(testPod("web", nil), "lossy", "b", "c", "d"))
+	if len(result.FailedNodes) != 0 {
+		t.Errorf("low coverage: failed %v, want every node passed through", result.FailedNodes)
+	}
+	if result.NodeNames == nil || len(*result.NodeNames) != 4 {
+		t.Errorf("low coverage: passed %v, want all four nodes", result.NodeNames)
+	}
+
+	// With half the candidates covered the thresholds apply again
+	result = callFilter(t, se, extenderArgs(testPod("web", nil), "lossy", "b"))
+	if _, failed := result.FailedNodes["lossy"]; !failed {
+		t.Error("node above the drop_rate threshold passed with enough coverage")
+	}
+}
+
+func TestLogScalingSpreadsLowLatencies(t *testing.T) {
+	spread := func(logScale bool) float64 {
+		return normalizeMetric(5, 0, 1000, true, logScale) - normalizeMetric(50, 0, 1000, true, logScale)
+	}
+	if linear, logScaled := spread(false), spread(true); logScaled <= 3*linear {
+		t.Errorf("5ms vs 50ms: log spread %v, linear %v; want log to separate them far more", logScaled, linear)
+	}
+
+	for _, value := range []float64{0, -10} {
+		if got := normalizeMetric(value, 0, 1000, true, true); got != 1 {
+			t.Errorf("log-scaled %v normalized to %v, want 1", value, got)
+		}
+	}
+	if got := normalizeMetric(1000, 0, 1000, true, true); got != 0 {
+		t.Errorf("log-scaled max normalized to %v, want 0", got)
+	}
+
+	// End to end, the 5ms node pulls further ahead of the 50ms one
+	nodes := func() []*NodeMetrics {
+		return []*NodeMetrics{{NodeName: "5ms", RTTp99: 5}, {NodeName: "50ms", RTTp99: 50}}
+	}
+	linear := metricScores(t, testConfig(t, nil), nodes()...)
+	logScaled := metricScores(t, testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.MetricScaling = map[string]string{"rtt_p99": scalingLog}
+	}), nodes()...)
+	if logScaled["5ms"]-logScaled["50ms"] <= linear["5ms"]-linear["50ms"] {
+		t.Errorf("log scaling gap %v, linear gap %v", logScaled["5ms"]-logScaled["50ms"], linear["5ms"]-linear["50ms"])
+	}
+}
+
+func TestProfileScheduleSelectsPeakWeights(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.Weights = ScoreWeights{CPUUtil: 1}
+		cfg.Profiles = map[string]WeightProfile{"peak": {ScoreWeights: ScoreWeights{RTTp99: 1}}}
+		cfg.ProfileSchedule = []ProfileWindow{
+			{Profile: "peak", Start: "11:00", End: "13:00"},
+			{Profile: "peak", Start: "22:00", End: "02:00", Days: []string{"thu"}},
+		}
+	})
+	se := newTestExtender(t, cfg, nil)
+	clock := newFakeClock() // Thursday 12:00
+	se.clock = clock
+	nodes := func() []*NodeMetrics {
+		return []*NodeMetrics{
+			{NodeName: "fast-busy", RTTp99: 20, CPUUtil: 90},
+			{NodeName: "slow-idle", RTTp99: 400, CPUUtil: 5},
+		}
+	}
+
+	se.seedCache(cfg, nodes()...)
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("noon", nil), "fast-busy", "slow-idle")))
+	if scores["fast-busy"] <= scores["slow-idle"] {
+		t.Errorf("inside peak window: fast-busy scored %d, slow-idle %d", scores["fast-busy"], scores["slow-idle"])
+	}
+
+	clock.advance(2 * time.Hour)
+	se.seedCache(cfg, nodes()...)
+	scores = scoresByHost(callPrioritize(t, se, extenderArgs(testPod("afternoon", nil), "fast-busy", "slow-idle")))
+	if scores["slow-idle"] <= scores["fast-busy"] {
+		t.Errorf("outside peak window: fast-busy scored %d, slow-idle %d", scores["fast-busy"], scores["slow-idle"])
+	}
+
+	// The overnight window belongs to the day it starts on
+	for _, tc := range []struct {
+		at   time.Time
+		want bool
+	}{
+		{time.Date(2026, 10, 15, 21, 59, 0, 0, time.UTC), false},
+		{time.Date(2026, 10, 15, 23, 0, 0, 0, time.UTC), true},
+		{time.Date(2026, 10, 16, 1, 59, 0, 0, time.UTC), true},
+		{time.Date(2026, 10, 16, 2, 0, 0, 0, time.UTC), false},
+		{time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC), false},
+	} {
+		profile := scheduledProfile(cfg, tc.at)
+		if got := profile != nil; got != tc.want {
+			t.Errorf("%s: peak selected = %v, want %v", tc.at.Format("Mon 15:04"), got, tc.want)
+		} else if got && profile.name != "peak" {
+			t.Errorf("%s: selected profile %q, want peak", tc.at.Format("Mon 15:04"), profile.name)
+		}
+	}
+}
+
+func TestMissingMetricScoredWorstCase(t *testing.T) {
+	scores := func(treatment string) map[string]int64 {
+		cfg := testConfig(t, func(cfg *ExtenderConfig) {
+			cfg.Weights.DropRate = 0.3
+			if treatment != "" {
+				cfg.MissingValues = map[string]string{"drop_rate": treatment}
+			}
+		})
+		se := newTestExtender(t, cfg, promSeries{
+			"ebpf_rtt_p99_milliseconds": {"reporting": 50, "crashed": 50},
+			"ebpf_drop_rate":            {"reporting": 5},
+		})
+		if err := se.updateMetrics(context.Background(), cfg); err != nil {
+			t.Fatalf("updateMetrics: %v", err)
+		}
+		return scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), "reporting", "crashed")))
+	}
+
+	// Untreated, a missing drop rate reads as zero drops
+	if untreated := scores(""); untreated["crashed"] < untreated["reporting"] {
+		t.Errorf("without a treatment the crashed exporter scored %d, reporting node %d", untreated["crashed"], untreated["reporting"])
+	}
+	worst := scores(missingWorst)
+	if worst["crashed"] >= worst["reporting"] {
+		t.Errorf("worst-case on missing: crashed exporter scored %d, reporting node %d", worst["crashed"], worst["reporting"])
+	}
+}
+
+func TestNegativeWeightRepels(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Weights.MPTCPSubflowHealth = -0.3
+	if validateConfig(cfg) == nil {
+		t.Error("negative weight accepted without AllowNegativeWeights")
+	}
+
+	cfg = testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.AllowNegativeWeights = true
+		cfg.Weights.MPTCPSubflowHealth = -0.3
+	})
+	scores := metricScores(t, cfg,
+		&NodeMetrics{NodeName: "low", RTTp99: 50, MPTCPSubflowHealth: 10},
+		&NodeMetrics{NodeName: "high", RTTp99: 50, MPTCPSubflowHealth: 90},
+	)
+	if scores["high"] >= scores["low"] {
+		t.Errorf("negative weight: raw 90 scored %v, raw 10 scored %v", scores["high"], scores["low"])
+	}
+	for name, score := range scores {
+		if score < minScore || score > maxScore {
+			t.Errorf("%s scored %v, outside %v-%v", name, score, minScore, maxScore)
+		}
+	}
+}
+
+func TestLowSampleCountContributesLess(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.SampleCountQueries = map[string]string{"rtt_p99": "ebpf_rtt_samples_total"}
+		cfg.MinSampleCount = 10
+	})
+	se := newTestExtender(t, cfg, promSeries{
+		"ebpf_rtt_p99_milliseconds": {"sparse": 900, "dense": 900},
+		"ebpf_rtt_samples_total":    {"sparse": 1, "dense": 100},
+	})
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+
+	// The same bad RTT drags the well-sampled node further from neutral
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), "sparse", "dense")))
+	if scores["sparse"] <= scores["dense"] {
+		t.Errorf("RTT from 1 sample scored %d, from 100 samples %d", scores["sparse"], scores["dense"])
+	}
+}
+
+func TestQoSClassSelectsProfile(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.Profiles = map[string]WeightProfile{
+			"latency":    {ScoreWeights: ScoreWeights{RTTp99: 1}},
+			"throughput": {ScoreWeights: ScoreWeights{CPUUtil: 1}},
+		}
+		cfg.QoSProfiles = map[string]string{"Guaranteed": "latency", "BestEffort": "throughput"}
+	})
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "fast-busy", RTTp99: 20, CPUUtil: 90},
+		&NodeMetrics{NodeName: "slow-idle", RTTp99: 400, CPUUtil: 5},
+	)
+
+	guaranteed := testPod("db", nil)
+	resources := v1core.ResourceList{
+		v1core.ResourceCPU:    resource.MustParse("1"),
+		v1core.ResourceMemory: resource.MustParse("1Gi"),
+	}
+	guaranteed.Spec.Containers = []v1core.Container{{
+		Name:      "db",
+		Resources: v1core.ResourceRequirements{Requests: resources, Limits: resources},
+	}}
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(guaranteed, "fast-busy", "slow-idle")))
+	if scores["fast-busy"] <= scores["slow-idle"] {
+		t.Errorf("Guaranteed pod: fast-busy scored %d, slow-idle %d", scores["fast-busy"], scores["slow-idle"])
+	}
+
+	scores = scoresByHost(callPrioritize(t, se, extenderArgs(testPod("batch", nil), "fast-busy", "slow-idle")))
+	if scores["slow-idle"] <= scores["fast-busy"] {
+		t.Errorf("BestEffort pod: fast-busy scored %d, slow-idle %d", scores["fast-busy"], scores["slow-idle"])
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// extenderMetrics are the extender's own Prometheus metrics, served on
+// /prom-metrics. /metrics predates them and serves the node metrics cache.
+type extenderMetrics struct {
+	registry *prometheus.Registry
+	// scores shows whether scoring discriminates between nodes or bunches
+	// them around the neutral score
+	scores prometheus.Histogram
+	// prioritizeDuration times prioritize from decoding the request to
+	// writing the response, by phase
+	prioritizeDuration *prometheus.HistogramVec
+	// queryWarnings counts Prometheus answers that came with warnings, by
+	// metric
+	queryWarnings *prometheus.CounterVec
+}
+
+// Phases of prioritizeDuration. phaseScore includes an on-demand metrics
+// refresh when the cache has expired.
+const (
+	phaseDecode = "decode"
+	phaseScore  = "score"
+	phaseEncode = "encode"
+	phaseTotal  = "total"
+)
+
+func newExtenderMetrics() *extenderMetrics {
+	m := &extenderMetrics{
+		registry: prometheus.NewRegistry(),
+		scores: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "scheduler_extender_node_score",
+			Help:    "Scores returned by prioritize, one observation per node.",
+			Buckets: prometheus.LinearBuckets(minScore, 10, 11),
+		}),
+		prioritizeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "scheduler_extender_prioritize_duration_seconds",
+			Help:    "Time spent serving prioritize requests, by phase.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"phase"}),
+		queryWarnings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scheduler_extender_query_warnings_total",
+			Help: "Prometheus query responses that carried warnings, by metric.",
+		}, []string{"metric"}),
+	}
+	m.registry.MustRegister(m.scores, m.prioritizeDuration, m.queryWarnings)
+	return m
+}
+
+func (m *extenderMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
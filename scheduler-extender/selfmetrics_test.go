@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrapePromMetrics returns the /prom-metrics exposition text.
+func scrapePromMetrics(t *testing.T, se *SchedulerExtender) string {
+	t.Helper()
+	_, metricsMux := se.routes(se.config.Load())
+	rec := httptest.NewRecorder()
+	metricsMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/prom-metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/prom-metrics: status %d", rec.Code)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+// promSample returns the value of the sample line starting with name.
+func promSample(t *testing.T, exposition, name string) string {
+	t.Helper()
+	for _, line := range strings.Split(exposition, "\n") {
+		if value, ok := strings.CutPrefix(line, name+" "); ok {
+			return value
+		}
+	}
+	t.Fatalf("no %s sample in:\n%s", name, exposition)
+	return ""
+}
+
+func TestScoreHistogramCountsNodes(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "a", RTTp99: 20},
+		&NodeMetrics{NodeName: "b", RTTp99: 200},
+		&NodeMetrics{NodeName: "c", RTTp99: 400},
+	)
+
+	callPrioritize(t, se, extenderArgs(testPod("web", nil), "a", "b", "c"))
+	if got := promSample(t, scrapePromMetrics(t, se), "scheduler_extender_node_score_count"); got != "3" {
+		t.Errorf("after scoring 3 nodes: histogram count %s, want 3", got)
+	}
+
+	callPrioritize(t, se, extenderArgs(testPod("api", nil), "a", "b"))
+	exposition := scrapePromMetrics(t, se)
+	if got := promSample(t, exposition, "scheduler_extender_node_score_count"); got != "5" {
+		t.Errorf("after scoring 2 more nodes: histogram count %s, want 5", got)
+	}
+	if got := promSample(t, exposition, `scheduler_extender_node_score_bucket{le="+Inf"}`); got != "5" {
+		t.Errorf("+Inf bucket %s, want 5", got)
+	}
+}
+
+func TestPrioritizeDurationObserved(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 20}, &NodeMetrics{NodeName: "b", RTTp99: 200})
+
+	for i := 1; i <= 3; i++ {
+		callPrioritize(t, se, extenderArgs(testPod(fmt.Sprintf("web-%d", i), nil), "a", "b"))
+		exposition := scrapePromMetrics(t, se)
+		for _, phase := range []string{phaseDecode, phaseScore, phaseEncode, phaseTotal} {
+			name := fmt.Sprintf(`scheduler_extender_prioritize_duration_seconds_count{phase=%q}`, phase)
+			if got := promSample(t, exposition, name); got != fmt.Sprint(i) {
+				t.Errorf("after %d requests: %s phase observed %s times", i, phase, got)
+			}
+		}
+	}
+}
+
+func TestQueryWarnings(t *testing.T) {
+	series := promSeries{"ebpf_rtt_p99_milliseconds": {"a": 20}}
+	counter := `scheduler_extender_query_warnings_total{metric="rtt_p99"}`
+
+	// By default warnings are counted but the answer is still used
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, series, "result truncated")
+	if err := se.updateMetrics(context.Background(), cfg); err != nil {
+		t.Fatalf("updateMetrics: %v", err)
+	}
+	if got := promSample(t, scrapePromMetrics(t, se), counter); got != "1" {
+		t.Errorf("warned queries counted %s, want 1", got)
+	}
+	se.mu.RLock()
+	m := se.metricsCache["a"]
+	se.mu.RUnlock()
+	if m == nil || m.RTTp99 != 20 {
+		t.Errorf("default: cached %+v, want RTT 20 despite the warning", m)
+	}
+
+	cfg = testConfig(t, func(cfg *ExtenderConfig) { cfg.FailOnQueryWarnings = true })
+	se = newTestExtender(t, cfg, series, "result truncated")
+	if err := se.updateMetrics(context.Background(), cfg); err == nil {
+		t.Error("FailOnQueryWarnings: refresh on warned answers succeeded")
+	}
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	if len(se.metricsCache) != 0 {
+		t.Errorf("FailOnQueryWarnings: cached %d nodes from warned answers", len(se.metricsCache))
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// withLoadShedding rejects requests with 429 while MaxConcurrentRequests
+// are already in flight, so kube-scheduler backs off during scheduling
+// storms instead of piling work onto the extender and Prometheus.
+func (se *SchedulerExtender) withLoadShedding(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := se.config.Load()
+		if cfg.MaxConcurrentRequests <= 0 {
+			next(w, r)
+			return
+		}
+
+		defer se.inflight.Add(-1)
+		if n := se.inflight.Add(1); n > int64(cfg.MaxConcurrentRequests) {
+			if cfg.Debug {
+				log.Printf("Shedding %s request, %d in flight", r.URL.Path, n-1)
+			}
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
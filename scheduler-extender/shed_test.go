@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestLoadSheddingReturns429(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) { cfg.MaxConcurrentRequests = 2 })
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg, &NodeMetrics{NodeName: "a", RTTp99: 5})
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	blocking := se.withLoadShedding(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Saturate the limiter with two stuck requests
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			blocking(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/prioritize", nil))
+		}()
+		<-entered
+	}
+
+	rec := httptest.NewRecorder()
+	body := extenderArgs(testPod("web", nil), "a")
+	se.withLoadShedding(se.prioritize)(rec, httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(body)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("third concurrent request: status %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+
+	close(release)
+	wg.Wait()
+	rec = httptest.NewRecorder()
+	se.withLoadShedding(se.prioritize)(rec, httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("request after the storm: status %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"golang.org/x/time/rate"
+)
+
+// runSimulation scores a captured metrics dump with the configured weights
+// and prints the ranking, for tuning weights offline. The dump is either
+// an array of NodeMetrics or the object served by /metrics.
+func runSimulation(path string, out io.Writer) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	// A dump is old by definition; score it as if it were fresh
+	cfg.MaxMetricAge = 0
+	cfg.MetricHalfLife = 0
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read metrics file: %w", err)
+	}
+	cache, err := parseMetricsDump(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse metrics file %s: %w", path, err)
+	}
+
+	precomputeScores(cfg, cache)
+	se := &SchedulerExtender{
+		metricsCache: cache,
+		clock:        realClock{},
+		accessLog:    newAccessLogger(),
+		podScores:    newPodScoreCache(),
+		history:      newScoreHistory(cfg.HistoryMaxEntries),
+		queryLimiter: rate.NewLimiter(rate.Inf, 1),
+		promMetrics:  newExtenderMetrics(),
+	}
+	se.config.Store(cfg)
+
+	type ranked struct {
+		node  string
+		score float64
+	}
+	results := make([]ranked, 0, len(cache))
+	for nodeName := range cache {
+		results = append(results, ranked{node: nodeName, score: se.calculateNodeScore(cfg, nodeName, scoreOptions{})})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].node < results[j].node
+	})
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tNODE\tSCORE")
+	for i, r := range results {
+		fmt.Fprintf(tw, "%d\t%s\t%.2f\n", i+1, r.node, r.score)
+	}
+	return tw.Flush()
+}
+
+// parseMetricsDump indexes a metrics dump by node name.
+func parseMetricsDump(data []byte) (map[string]*NodeMetrics, error) {
+	var list []*NodeMetrics
+	if err := json.Unmarshal(data, &list); err == nil {
+		cache := make(map[string]*NodeMetrics, len(list))
+		for i, m := range list {
+			if m == nil || m.NodeName == "" {
+				return nil, fmt.Errorf("entry %d has no node_name", i)
+			}
+			cache[m.NodeName] = m
+		}
+		return cache, nil
+	}
+
+	var cache map[string]*NodeMetrics
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	for nodeName, m := range cache {
+		if m == nil {
+			delete(cache, nodeName)
+		}
+	}
+	return cache, nil
+}
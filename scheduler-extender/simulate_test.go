@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rankedNodes returns the node column of a simulation table.
+func rankedNodes(t *testing.T, table string) []string {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(table), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "RANK") {
+		t.Fatalf("no header in simulation output:\n%s", table)
+	}
+	var nodes []string
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			t.Fatalf("malformed row %q", line)
+		}
+		nodes = append(nodes, fields[1])
+	}
+	return nodes
+}
+
+func TestSimulateRanksFixture(t *testing.T) {
+	var out bytes.Buffer
+	if err := runSimulation(filepath.Join("testdata", "metrics-dump.json"), &out); err != nil {
+		t.Fatalf("runSimulation: %v", err)
+	}
+	if got := strings.Join(rankedNodes(t, out.String()), ","); got != "edge-a,edge-b,edge-c" {
+		t.Errorf("ranking %s, want edge-a,edge-b,edge-c:\n%s", got, out.String())
+	}
+
+	// A /metrics dump ranks the same
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	os.WriteFile(path, []byte(`{"edge-c":{"node_name":"edge-c","rtt_p99_ms":850},"edge-a":{"node_name":"edge-a","rtt_p99_ms":12}}`), 0o644)
+	out.Reset()
+	if err := runSimulation(path, &out); err != nil {
+		t.Fatalf("runSimulation on a /metrics dump: %v", err)
+	}
+	if got := strings.Join(rankedNodes(t, out.String()), ","); got != "edge-a,edge-c" {
+		t.Errorf("ranking %s, want edge-a,edge-c", got)
+	}
+
+	if err := runSimulation(filepath.Join("testdata", "missing.json"), &out); err == nil {
+		t.Error("missing dump accepted")
+	}
+}
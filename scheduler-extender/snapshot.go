@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// snapshotResponse is everything needed to replay scoring after the fact.
+type snapshotResponse struct {
+	Timestamp     time.Time               `json:"timestamp"`
+	ConfigVersion string                  `json:"config_version"`
+	LastRefresh   *time.Time              `json:"last_refresh"`
+	Nodes         map[string]*NodeMetrics `json:"nodes"`
+	// Scores are the pod-independent metric scores, with the profile
+	// scheduled at Timestamp
+	Scores map[string]float64 `json:"scores"`
+}
+
+// snapshotHandler returns the cache and the scores computed from it as of
+// one instant, for archiving after an incident.
+func (se *SchedulerExtender) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := se.config.Load()
+
+	// Scoring stores results on the entry it scores, so score copies and
+	// leave the live entries to prioritize
+	se.mu.RLock()
+	now := se.clock.Now()
+	opts := scoreOptions{profile: scheduledProfile(cfg, now)}
+	resp := snapshotResponse{
+		Timestamp:     now,
+		ConfigVersion: cfg.version,
+		Nodes:         make(map[string]*NodeMetrics, len(se.metricsCache)),
+		Scores:        make(map[string]float64, len(se.metricsCache)),
+	}
+	if !se.lastUpdate.IsZero() {
+		lastUpdate := se.lastUpdate
+		resp.LastRefresh = &lastUpdate
+	}
+	for nodeName, metrics := range se.metricsCache {
+		entry := *metrics
+		resp.Scores[nodeName] = se.scoreEntry(cfg, nodeName, &entry, opts)
+		resp.Nodes[nodeName] = &entry
+	}
+	se.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func getSnapshot(t *testing.T, se *SchedulerExtender) snapshotResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	se.snapshotHandler(rec, httptest.NewRequest(http.MethodGet, "/snapshot", nil))
+	var snap snapshotResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("decoding snapshot: %v: %s", err, rec.Body)
+	}
+	return snap
+}
+
+func TestSnapshotConsistent(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "a", RTTp99: 10, CPUUtil: 20},
+		&NodeMetrics{NodeName: "b", RTTp99: 600, CPUUtil: 90, DropRate: 5},
+	)
+
+	snap := getSnapshot(t, se)
+	if snap.ConfigVersion != cfg.version {
+		t.Errorf("config version %q, want %q", snap.ConfigVersion, cfg.version)
+	}
+	if snap.LastRefresh == nil || !snap.LastRefresh.Equal(se.lastUpdate) {
+		t.Errorf("last refresh %v, want %v", snap.LastRefresh, se.lastUpdate)
+	}
+	if len(snap.Nodes) != 2 || len(snap.Scores) != 2 {
+		t.Fatalf("snapshot has %d nodes and %d scores, want 2 of each", len(snap.Nodes), len(snap.Scores))
+	}
+	for nodeName, score := range snap.Scores {
+		node, ok := snap.Nodes[nodeName]
+		if !ok {
+			t.Errorf("score for %s without its metrics", nodeName)
+			continue
+		}
+		if node.Score != score {
+			t.Errorf("%s: entry score %v, snapshot score %v", nodeName, node.Score, score)
+		}
+		sum := 0.0
+		for _, c := range node.Breakdown {
+			sum += c.Contribution
+		}
+		if diff := sum - score; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%s: breakdown sums to %v, score is %v", nodeName, sum, score)
+		}
+	}
+	if snap.Scores["a"] <= snap.Scores["b"] {
+		t.Errorf("scores %v don't follow the metrics", snap.Scores)
+	}
+
+	// The live entries are left alone
+	for nodeName, metrics := range se.metricsCache {
+		if metrics.Score != 0 || metrics.Breakdown != nil {
+			t.Errorf("snapshot scored the live entry for %s", nodeName)
+		}
+	}
+}
+
+// Run under -race alongside prioritize, which scores the live entries.
+func TestSnapshotConcurrentWithPrioritize(t *testing.T) {
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, nil)
+	se.seedCache(cfg,
+		&NodeMetrics{NodeName: "a", RTTp99: 10},
+		&NodeMetrics{NodeName: "b", RTTp99: 600},
+	)
+	body := extenderArgs(testPod("web", nil), "a", "b")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			rec := httptest.NewRecorder()
+			se.prioritize(rec, httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Errorf("prioritize status %d: %s", rec.Code, rec.Body)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		getSnapshot(t, se)
+	}
+	wg.Wait()
+}
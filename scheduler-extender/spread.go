@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+)
+
+// revisionLabels differ between pods of one workload (per rollout or per
+// replica), so they are left out when matching siblings.
+var revisionLabels = []string{
+	"pod-template-hash",
+	"controller-revision-hash",
+	"statefulset.kubernetes.io/pod-name",
+	"apps.kubernetes.io/pod-index",
+}
+
+// workloadSelector matches the pods of pod's workload: same namespace and
+// the same labels, ignoring revisionLabels. It returns nil for pods
+// without labels, which can't be told apart from unrelated pods.
+func workloadSelector(pod *v1core.Pod) labels.Selector {
+	if pod == nil {
+		return nil
+	}
+	set := labels.Set{}
+	for key, value := range pod.Labels {
+		set[key] = value
+	}
+	for _, key := range revisionLabels {
+		delete(set, key)
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	return labels.SelectorFromSet(set)
+}
+
+// siblingCounts counts the running or pending pods of pod's workload on
+// each node, or returns nil if pods can't be listed.
+func siblingCounts(lister listersv1.PodLister, pod *v1core.Pod) map[string]int {
+	selector := workloadSelector(pod)
+	if lister == nil || selector == nil {
+		return nil
+	}
+	siblings, err := lister.Pods(pod.Namespace).List(selector)
+	if err != nil {
+		log.Printf("Failed to list siblings of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, sibling := range siblings {
+		if sibling.UID == pod.UID || sibling.Spec.NodeName == "" {
+			continue
+		}
+		if sibling.Status.Phase == v1core.PodSucceeded || sibling.Status.Phase == v1core.PodFailed {
+			continue
+		}
+		counts[sibling.Spec.NodeName]++
+	}
+	return counts
+}
+
+// spreadPenalty scales penalty by how many siblings nodeName runs relative
+// to the busiest candidate.
+func spreadPenalty(penalty float64, counts map[string]int, nodeName string, maxCount int) float64 {
+	if maxCount == 0 {
+		return 0
+	}
+	return penalty * float64(counts[nodeName]) / float64(maxCount)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	v1core "k8s.io/api/core/v1"
+)
+
+// preferredZoneAnnotation names the zone a pod would like to run in, e.g.
+// the zone of the database a latency-sensitive service talks to.
+const preferredZoneAnnotation = "ebpf-scheduler/preferred-zone"
+
+// podPreferredZone returns the zone pod prefers, or "" for none.
+func podPreferredZone(pod *v1core.Pod) string {
+	if pod == nil {
+		return ""
+	}
+	return pod.Annotations[preferredZoneAnnotation]
+}
+
+// nodeInZone reports whether node carries the well-known zone label for zone.
+func nodeInZone(node *v1core.Node, zone string) bool {
+	return node != nil && zone != "" && node.Labels[v1core.LabelTopologyZone] == zone
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPrioritizeSpans(t *testing.T) {
+	// The global provider only delegates once, so this is the one test that
+	// installs it
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	if _, err := initTracing(context.Background(), "", false); err != nil {
+		t.Fatalf("initTracing: %v", err)
+	}
+
+	cfg := testConfig(t, nil)
+	se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": {"a": 10}})
+	req := httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(extenderArgs(testPod("web", nil), "a")))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	se.prioritize(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("prioritize status %d: %s", rec.Code, rec.Body)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	prioritize, ok := spans["prioritize"]
+	if !ok {
+		t.Fatalf("no prioritize span among %d spans", len(exporter.GetSpans()))
+	}
+	if got := prioritize.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("prioritize span in trace %s, want the caller's trace", got)
+	}
+	if got := prioritize.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("prioritize span parent %s, want the caller's span", got)
+	}
+
+	// The refresh it triggered hangs off it, with queries below that
+	update, ok := spans["updateMetrics"]
+	if !ok || update.Parent.SpanID() != prioritize.SpanContext.SpanID() {
+		t.Errorf("updateMetrics span missing or not a child of prioritize")
+	}
+	if query, ok := spans["prometheus.query"]; !ok || query.Parent.SpanID() != update.SpanContext.SpanID() {
+		t.Errorf("prometheus.query span missing or not a child of updateMetrics")
+	}
+}
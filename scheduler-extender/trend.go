@@ -0,0 +1,96 @@
+package main
+
+import "math"
+
+// trendPoint is one refresh's metric values for a node.
+type trendPoint struct {
+	timestamp int64
+	values    map[string]float64
+}
+
+// recordTrend adds m's values to the recent values carried over from prev
+// and fits each metric's change over them.
+func recordTrend(cfg *ExtenderConfig, specs []metricSpec, m, prev *NodeMetrics) {
+	point := trendPoint{timestamp: m.Timestamp, values: make(map[string]float64, len(specs))}
+	for _, spec := range specs {
+		if value, ok := spec.get(m); ok && !math.IsNaN(value) && !math.IsInf(value, 0) {
+			point.values[spec.name] = value
+		}
+	}
+
+	if prev != nil {
+		keep := prev.recent
+		if len(keep) >= cfg.TrendWindow {
+			keep = keep[len(keep)-cfg.TrendWindow+1:]
+		}
+		m.recent = append(append(make([]trendPoint, 0, cfg.TrendWindow), keep...), point)
+	} else {
+		m.recent = []trendPoint{point}
+	}
+
+	for _, spec := range specs {
+		if change, ok := fitChange(m.recent, spec.name); ok {
+			if m.Trend == nil {
+				m.Trend = make(map[string]float64)
+			}
+			m.Trend[spec.name] = change
+		}
+	}
+}
+
+// fitChange fits a least-squares line through the metric's values over
+// time and returns its rise across the points' time span. Times are
+// centred on their mean first; squaring raw Unix seconds leaves too
+// little precision for the slope.
+func fitChange(points []trendPoint, name string) (float64, bool) {
+	var n, sumT, sumV float64
+	first, last := int64(math.MaxInt64), int64(math.MinInt64)
+	for _, p := range points {
+		v, ok := p.values[name]
+		if !ok {
+			continue
+		}
+		n++
+		sumT += float64(p.timestamp - points[0].timestamp)
+		sumV += v
+		first, last = min(first, p.timestamp), max(last, p.timestamp)
+	}
+	if n < 2 || first == last {
+		return 0, false
+	}
+	meanT, meanV := sumT/n, sumV/n
+
+	var sumTT, sumTV float64
+	for _, p := range points {
+		v, ok := p.values[name]
+		if !ok {
+			continue
+		}
+		dt := float64(p.timestamp-points[0].timestamp) - meanT
+		sumTT += dt * dt
+		sumTV += dt * (v - meanV)
+	}
+	return sumTV / sumTT * float64(last-first), true
+}
+
+// trendPenalty scales TrendPenalty by how far the weighted metrics have
+// worsened over the trend window, each relative to its normalization
+// range and weighted like in the score.
+func trendPenalty(cfg *ExtenderConfig, m *NodeMetrics, components map[string]ScoreComponent) float64 {
+	if cfg.TrendPenalty <= 0 || len(m.Trend) == 0 {
+		return 0
+	}
+	worsening := 0.0
+	for name, c := range components {
+		change, ok := m.Trend[name]
+		spec, known := cfg.metricSpec(name)
+		if !ok || !known {
+			continue
+		}
+		if spec.lowerIsBetter == c.Inverted {
+			change = -change
+		}
+		worsening += c.Weight * math.Min(math.Max(change/(spec.max-spec.min), 0), 1)
+	}
+	return cfg.TrendPenalty * worsening
+}
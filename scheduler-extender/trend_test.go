@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func trendSeries(start, spacing int64, values ...float64) []trendPoint {
+	points := make([]trendPoint, len(values))
+	for i, v := range values {
+		points[i] = trendPoint{
+			timestamp: start + int64(i)*spacing,
+			values:    map[string]float64{"rtt_p99": v},
+		}
+	}
+	return points
+}
+
+func TestFitChangeRealisticTimestamps(t *testing.T) {
+	const start = 1760000000
+	for _, spacing := range []int64{1, 10, 30, 300} {
+		change, ok := fitChange(trendSeries(start, spacing, 10, 20, 30, 40, 50), "rtt_p99")
+		if !ok {
+			t.Fatalf("spacing %ds: no fit", spacing)
+		}
+		if math.Abs(change-40) > 1e-6 {
+			t.Errorf("spacing %ds: change = %v, want 40", spacing, change)
+		}
+	}
+}
+
+func TestFitChangeNoisyDecrease(t *testing.T) {
+	change, ok := fitChange(trendSeries(1760000000, 10, 50, 52, 40, 35, 30), "rtt_p99")
+	if !ok || change >= 0 {
+		t.Fatalf("change = %v, %v; want a decrease", change, ok)
+	}
+}
+
+func TestFitChangeNeedsTwoTimes(t *testing.T) {
+	if _, ok := fitChange(trendSeries(1760000000, 10, 10), "rtt_p99"); ok {
+		t.Error("fit from a single point")
+	}
+	if _, ok := fitChange(trendSeries(1760000000, 0, 10, 20), "rtt_p99"); ok {
+		t.Error("fit from points at one instant")
+	}
+	if _, ok := fitChange(trendSeries(1760000000, 10, 10, 20), "drop_rate"); ok {
+		t.Error("fit for a metric without values")
+	}
+}
+
+func TestRecordTrendKeepsWindow(t *testing.T) {
+	cfg := &ExtenderConfig{TrendWindow: 3}
+	spec := *lookupMetricSpec("rtt_p99")
+
+	var prev *NodeMetrics
+	for i, rtt := range []float64{10, 20, 30, 40, 50} {
+		m := &NodeMetrics{Timestamp: 1760000000 + int64(i)*10, RTTp99: rtt}
+		recordTrend(cfg, []metricSpec{spec}, m, prev)
+		prev = m
+	}
+	if len(prev.recent) != 3 {
+		t.Fatalf("kept %d points, want 3", len(prev.recent))
+	}
+	if got := prev.Trend["rtt_p99"]; math.Abs(got-20) > 1e-6 {
+		t.Errorf("trend = %v, want 20 over the last 3 refreshes", got)
+	}
+}
+
+func TestRisingRTTScoresBelowFlatPeer(t *testing.T) {
+	cfg := testConfig(t, func(cfg *ExtenderConfig) {
+		cfg.TrendPenalty = 20
+		cfg.TrendWindow = 4
+	})
+	rtt := map[string]float64{}
+	se := newTestExtender(t, cfg, promSeries{"ebpf_rtt_p99_milliseconds": rtt})
+	clock := newFakeClock()
+	se.clock = clock
+
+	for _, rising := range []float64{50, 100, 150, 200} {
+		rtt["rising"], rtt["flat"] = rising, 200
+		if err := se.updateMetrics(context.Background(), cfg); err != nil {
+			t.Fatalf("updateMetrics: %v", err)
+		}
+		clock.advance(30 * time.Second)
+	}
+
+	scores := scoresByHost(callPrioritize(t, se, extenderArgs(testPod("web", nil), "rising", "flat")))
+	if scores["rising"] >= scores["flat"] {
+		t.Errorf("rising RTT scored %d, flat RTT %d at the same current value", scores["rising"], scores["flat"])
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
@@ -11,8 +14,23 @@ import (
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 )
 
+const (
+	// networkTierLabel classifies a node's network quality, e.g. "premium"
+	networkTierLabel = "network-tier"
+	// scoreAdjustAnnotation adds a signed offset to a node's score
+	scoreAdjustAnnotation = "network-aware-scheduler/score-adjust"
+)
+
+// defaultTierScores maps network-tier label values to score bonuses.
+var defaultTierScores = map[string]int64{
+	"premium":  40,
+	"standard": 20,
+	"basic":    10,
+}
+
 type NetworkAwareScheduler struct {
-	client kubernetes.Interface
+	client     kubernetes.Interface
+	tierScores map[string]int64
 }
 
 func main() {
@@ -26,7 +44,10 @@ func main() {
 		log.Fatalf("Failed to create clientset: %v", err)
 	}
 
-	scheduler := &NetworkAwareScheduler{client: clientset}
+	scheduler := &NetworkAwareScheduler{
+		client:     clientset,
+		tierScores: parseTierScores(os.Getenv("NETWORK_TIER_SCORES")),
+	}
 
 	http.HandleFunc("/filter", scheduler.filter)
 	http.HandleFunc("/prioritize", scheduler.prioritize)
@@ -80,29 +101,60 @@ func (s *NetworkAwareScheduler) prioritize(w http.ResponseWriter, r *http.Reques
 }
 
 func (s *NetworkAwareScheduler) calculateNetworkScore(node v1.Node) int64 {
-	// Simulate network-aware scoring based on node properties
 	score := int64(50) // Base score
-	
-	// Prefer nodes with lower indices (simulating better network conditions)
-	if len(node.Name) > 0 {
-		lastChar := node.Name[len(node.Name)-1:]
-		switch lastChar {
-		case "1":
-			score += 40 // Best network performance
-		case "2":
-			score += 20 // Medium network performance  
-		case "3":
-			score += 10 // Lower network performance
+
+	// Prefer nodes on a better network tier
+	if tier, ok := node.Labels[networkTierLabel]; ok {
+		score += s.tierScores[tier]
+	}
+
+	// Let operators nudge individual nodes
+	if adjust, ok := node.Annotations[scoreAdjustAnnotation]; ok {
+		if delta, err := strconv.ParseInt(adjust, 10, 64); err == nil {
+			score += delta
+		} else {
+			log.Printf("Ignoring invalid %s annotation on node %s: %q", scoreAdjustAnnotation, node.Name, adjust)
 		}
 	}
-	
+
 	// Consider node readiness
 	for _, condition := range node.Status.Conditions {
 		if condition.Type == v1.NodeReady && condition.Status == v1.ConditionTrue {
 			score += 10
 		}
 	}
-	
+
+	if score < 0 {
+		score = 0
+	}
+	if score > 100 {
+		score = 100
+	}
+
 	log.Printf("Calculated score for node %s: %d", node.Name, score)
 	return score
 }
+
+// parseTierScores parses "tier=score,tier=score", falling back to
+// defaultTierScores when unset.
+func parseTierScores(value string) map[string]int64 {
+	if value == "" {
+		return defaultTierScores
+	}
+
+	scores := make(map[string]int64)
+	for _, pair := range strings.Split(value, ",") {
+		tier, score, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Printf("Ignoring invalid tier score %q", pair)
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(score), 10, 64)
+		if err != nil {
+			log.Printf("Ignoring invalid tier score %q", pair)
+			continue
+		}
+		scores[strings.TrimSpace(tier)] = n
+	}
+	return scores
+}
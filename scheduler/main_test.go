@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func fakeNode(name, tier, adjust string, ready bool) v1.Node {
+	node := v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	}}
+	if tier != "" {
+		node.Labels[networkTierLabel] = tier
+	}
+	if adjust != "" {
+		node.Annotations[scoreAdjustAnnotation] = adjust
+	}
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: status}}
+	return node
+}
+
+func TestPrioritizeOrdersByTier(t *testing.T) {
+	s := &NetworkAwareScheduler{tierScores: defaultTierScores}
+	nodes := []v1.Node{
+		fakeNode("unlabelled", "", "", true),
+		fakeNode("basic", "basic", "", true),
+		fakeNode("premium", "premium", "", true),
+		fakeNode("standard", "standard", "", true),
+		fakeNode("premium-not-ready", "premium", "", false),
+	}
+	body, _ := json.Marshal(extenderv1.ExtenderArgs{Nodes: &v1.NodeList{Items: nodes}})
+
+	rec := httptest.NewRecorder()
+	s.prioritize(rec, httptest.NewRequest(http.MethodPost, "/prioritize", bytes.NewReader(body)))
+	var result extenderv1.HostPriorityList
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decoding prioritize response: %v: %s", err, rec.Body)
+	}
+	scores := make(map[string]int64, len(result))
+	for _, hp := range result {
+		scores[hp.Host] = hp.Score
+	}
+
+	order := []string{"premium", "premium-not-ready", "standard", "basic", "unlabelled"}
+	for i := 1; i < len(order); i++ {
+		if scores[order[i-1]] <= scores[order[i]] {
+			t.Errorf("%s (%d) does not outrank %s (%d)", order[i-1], scores[order[i-1]], order[i], scores[order[i]])
+		}
+	}
+}
+
+func TestScoreAdjustAnnotation(t *testing.T) {
+	s := &NetworkAwareScheduler{tierScores: defaultTierScores}
+	base := s.calculateNetworkScore(fakeNode("n", "standard", "", true))
+
+	tests := []struct {
+		adjust string
+		want   int64
+	}{
+		{"15", base + 15},
+		{"-25", base - 25},
+		{"+5", base + 5},
+		{"not-a-number", base},
+		{"1000", 100},
+		{"-1000", 0},
+	}
+	for _, tt := range tests {
+		if got := s.calculateNetworkScore(fakeNode("n", "standard", tt.adjust, true)); got != tt.want {
+			t.Errorf("adjust %q: score %d, want %d", tt.adjust, got, tt.want)
+		}
+	}
+}
+
+func TestParseTierScores(t *testing.T) {
+	if got := parseTierScores(""); got["premium"] != defaultTierScores["premium"] {
+		t.Errorf("unset: %v, want the defaults", got)
+	}
+
+	got := parseTierScores("gold=30, silver = 15,broken,bronze=x")
+	want := map[string]int64{"gold": 30, "silver": 15}
+	if len(got) != len(want) {
+		t.Fatalf("parsed %v, want %v", got, want)
+	}
+	for tier, score := range want {
+		if got[tier] != score {
+			t.Errorf("%s = %d, want %d", tier, got[tier], score)
+		}
+	}
+
+	// Custom tiers replace the defaults
+	s := &NetworkAwareScheduler{tierScores: got}
+	if s.calculateNetworkScore(fakeNode("a", "gold", "", true)) <= s.calculateNetworkScore(fakeNode("b", "premium", "", true)) {
+		t.Error("configured tier does not outrank a tier missing from the map")
+	}
+}